@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,10 +21,13 @@ import (
 type Call struct {
 	Seq           uint64
 	ServiceMethod string
+	Group         string // 访问的 group，为空时访问服务端默认的 group
 	Args          interface{}
 	Reply         interface{}
 	Error         error
-	Done          chan *Call // 通道（Done）来通知调用完成。
+	Done          chan *Call    // 通道（Done）来通知调用完成。
+	stream        *StreamReader // 流式调用时接收数据帧，普通调用为空
+	buf           []byte        // 普通调用收到流式响应时，暂存已收到的数据帧
 }
 
 // 当调用结束时，会调用 call.done() 通知调用方
@@ -41,6 +45,10 @@ type Client struct {
 	pending  map[uint64]*Call // 存储未处理完的请求，key 是请求的 seq，value 是请求的 Call 实例
 	closing  bool             // 用户主动关闭
 	shutdown bool             // 服务器关闭
+	closeErr error            // 不为空时，连接关闭后以该错误结束未完成的调用，例如 keepalive 超时或者认证失败
+	lastRecv atomic.Int64     // 最近一次收到服务端数据的时间（UnixNano），用于 keepalive
+	load     atomic.Int64     // 服务端在最近一次响应中上报的负载
+	done     chan struct{}    // 接收协程退出时关闭
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -58,6 +66,12 @@ func (client *Client) Close() error {
 	println("Client Close")
 	return client.cc.Close()
 }
+
+// serverLoad 返回服务端在最近一次响应中上报的正在处理的请求数。
+func (client *Client) serverLoad() int64 {
+	return client.load.Load()
+}
+
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -69,6 +83,10 @@ func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	if client.closing || client.shutdown {
+		// 连接因为服务端的错误（例如认证失败）关闭时返回该错误
+		if client.shutdown && client.closeErr != nil {
+			return 0, client.closeErr
+		}
 		return 0, ErrShutdown
 	}
 	call.Seq = client.seq
@@ -100,10 +118,18 @@ func (client *Client) terminateCalls(err error) {
 	}
 }
 
+// 根据 seq 查找 client.pending 中对应的 call，但不移除，用于接收流式响应的中间帧
+func (client *Client) getCall(seq uint64) *Call {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.pending[seq]
+}
+
 // 实现接收功能，接收到的响应有三种情况：
 // call 不存在，可能是请求没有发送完整，或者因为其他原因被取消，但是服务端仍旧处理了。
 // call 存在，但服务端处理出错，即 h.Error 不为空。
 // call 存在，服务端处理正常，那么需要从 body 中读取 Reply 的值。
+// 流式响应的中间帧（More 为 true）只追加数据，收到最后一帧时才结束调用。
 func (client *Client) recieve() {
 	var err error
 	for err == nil {
@@ -111,16 +137,45 @@ func (client *Client) recieve() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		client.lastRecv.Store(time.Now().UnixNano())
+		if h.Ping || h.Pong {
+			err = client.recieveControl(&h)
+			continue
+		}
+		if h.Stream && h.More {
+			err = client.readFrame(&h, client.getCall(h.Seq))
+			continue
+		}
+		// Seq 为 0 的错误响应不属于任何调用，是服务端关闭连接之前发送的错误，例如认证失败
+		if h.Seq == 0 && h.Error != "" {
+			_ = client.cc.ReadBody(nil)
+			client.mu.Lock()
+			if client.closeErr == nil {
+				client.closeErr = remoteError(&h)
+			}
+			client.mu.Unlock()
+			err = ErrShutdown
+			break
+		}
+		client.load.Store(h.Load)
 		call := client.removeCall(h.Seq)
 		switch {
 		case call == nil:
 			err = client.cc.ReadBody(nil)
 			log.Println(err)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = remoteError(&h)
 			err = client.cc.ReadBody(nil)
 			log.Println(err)
 			call.done()
+		case h.Stream:
+			if err = client.readFrame(&h, call); err == nil && call.stream == nil {
+				call.Error = setStreamReply(call.Reply, call.buf)
+			}
+			if err != nil {
+				call.Error = errors.New("reading body " + err.Error())
+			}
+			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
@@ -130,7 +185,30 @@ func (client *Client) recieve() {
 			call.done()
 		}
 	}
+	client.mu.Lock()
+	if client.closeErr != nil {
+		err = client.closeErr
+	}
+	client.mu.Unlock()
 	client.terminateCalls(err)
+	close(client.done)
+}
+
+// recieveControl 读取 keepalive 探测帧，收到 Ping 时回复 Pong。
+// 在新的协程中回复，避免接收协程阻塞在写操作上。
+func (client *Client) recieveControl(h *codec.Header) error {
+	var timestamp int64
+	if err := client.cc.ReadBody(&timestamp); err != nil {
+		return err
+	}
+	if h.Ping {
+		go func() {
+			if err := client.sendControl(codec.Header{Pong: true}, timestamp); err != nil {
+				log.Println("rpc client: send pong error:", err)
+			}
+		}()
+	}
+	return nil
 }
 
 // NewHTTPClient new a Client instance via HTTP as transport protocol
@@ -164,13 +242,17 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
+	// 当前版本的客户端都能够接收流式响应，拷贝一份 Option 以免修改调用方传入的配置
+	handshake := *opt
+	handshake.Streaming = true
+	handshake.StreamWindow = defaultStreamWindow
 	// send options with server
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	if err := json.NewEncoder(conn).Encode(&handshake); err != nil {
 		log.Println("rpc client: options error: ", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	return newClientCodec(newCodec(f, conn, opt), opt), nil
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -179,8 +261,11 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		cc:      cc,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		done:    make(chan struct{}),
 	}
+	client.lastRecv.Store(time.Now().UnixNano())
 	go client.recieve()
+	client.startKeepalive()
 	return client
 }
 
@@ -218,6 +303,7 @@ func (client *Client) send(call *Call) {
 	// 填充 Header
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
+	client.header.Group = call.Group
 	client.header.Error = ""
 	// 发送请求
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -245,6 +331,11 @@ func (client *Client) send(call *Call) {
 // 返回值:
 //   - *Call: 表示此次 RPC 调用的结构体。
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return client.GoGroup("", serviceMethod, args, reply, done)
+}
+
+// GoGroup 与 Go 相同，但是访问服务端名为 group 的 Group。
+func (client *Client) GoGroup(group, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 100)
 	} else if cap(done) == 0 {
@@ -253,6 +344,7 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 
 	call := &Call{
 		ServiceMethod: serviceMethod,
+		Group:         group,
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
@@ -263,12 +355,17 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 }
 
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	return client.CallGroup(ctx, "", serviceMethod, args, reply)
+}
+
+// CallGroup 与 Call 相同，但是访问服务端名为 group 的 Group。
+func (client *Client) CallGroup(ctx context.Context, group, serviceMethod string, args, reply interface{}) error {
+	call := client.GoGroup(group, serviceMethod, args, reply, make(chan *Call, 1))
 	log.Println("select :")
 	select {
 	case <-ctx.Done():
 		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		return fmt.Errorf("rpc client: call failed: %w", ctx.Err())
 	case call := <-call.Done:
 		return call.Error
 	}
@@ -293,7 +390,8 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	// 尝试在指定的超时时间内建立网络连接。
 	// 参数 network 指定要使用的网络类型（如 "tcp", "udp" 或 "unix"），
 	// 而 address 则是根据所选网络类型的具体地址（例如，对于 TCP，它可能是 "hostname:port"）。
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	// network 为 tls 或者设置了 opt.TLSConfig 时，会在建立连接后完成 TLS 握手。
+	conn, err := dialConn(network, address, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -329,6 +427,7 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
 	}
+	// protocol 为 tls 时使用 TLS 连接，例如 tls@localhost:1234
 	protocol, addr := parts[0], parts[1]
 	return Dial(protocol, addr, opts...)
 }
@@ -0,0 +1,88 @@
+package distributecache
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingConn 统计 Write 的调用次数，每次 Write 对应一次写系统调用。
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+// countingListener 把接受的连接包装为 countingConn。
+type countingListener struct {
+	net.Listener
+	writes *atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, writes: l.writes}, nil
+}
+
+// benchmarkCall 并发地调用 Group.Get，报告客户端和服务端每次调用的写系统调用次数。
+func benchmarkCall(b *testing.B, linger time.Duration) {
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(w)
+	g := NewGroup(b.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	var writes atomic.Int64
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer lis.Close()
+	go NewServer(g, b.Name(), "tcp@"+lis.Addr().String()).Accept(&countingListener{Listener: lis, writes: &writes})
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType, WriteLinger: linger}
+	client, err := NewClient(&countingConn{Conn: conn, writes: &writes}, opt)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	key := "key"
+	var reply string
+	if err := client.Call(context.Background(), "Group.Get", &key, &reply); err != nil {
+		b.Fatal(err)
+	}
+	writes.Store(0)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key, reply := "key", ""
+		for pb.Next() {
+			if err := client.Call(context.Background(), "Group.Get", &key, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+}
+
+func BenchmarkCall(b *testing.B) {
+	b.Run("unbatched", func(b *testing.B) { benchmarkCall(b, 0) })
+	b.Run("batched", func(b *testing.B) { benchmarkCall(b, 100*time.Microsecond) })
+}
@@ -2,6 +2,10 @@ package distributecache
 
 import (
 	"DistributeCache/codec"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +15,7 @@ import (
 	"net/http"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +26,18 @@ type Option struct {
 	CodecType      codec.Type
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	TLSConfig      *tls.Config   `json:"-"` // 不为空时客户端使用 TLS 连接服务端，不参与协议协商
+	Identity       string        // 客户端声明的身份，服务端认证通过后替换为认证得到的身份
+	Token          string        // bearer token，或者由 SignOption 生成的 HMAC 签名
+	Timestamp      int64         // HMAC 签名时的 Unix 时间戳（秒）
+	Nonce          string        // HMAC 签名时的随机数，每个连接不同，防止握手被重放
+	Streaming      bool          // 客户端能够接收流式响应，由 NewClient 设置
+	StreamWindow   int           // 大于 0 时开启流式响应的流量控制，服务端最多发送该数量的未确认帧，由 NewClient 设置
+	WriteLinger    time.Duration // 大于 0 时客户端和服务端都开启批量写，在该时间窗口内合并多次写入
+	// 大于 0 时客户端开启 keepalive，连接空闲超过 KeepaliveInterval 时发送探测帧，
+	// 之后 KeepaliveTimeout 内没有收到服务端的数据则关闭连接，KeepaliveTimeout 为 0 时与 KeepaliveInterval 相同
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
 }
 
 var DefaultOption = &Option{
@@ -40,9 +57,18 @@ var DefaultOption = &Option{
 // | Option | Header1 | Body1 | Header2 | Body2 | ...
 
 type Server struct {
-	gee  *Group
-	ID   string
-	Addr string
+	gee    *Group
+	ID     string
+	Addr   string
+	auth   Authenticator // 为空时不做认证
+	policy Policy        // 为空时不做授权检查
+
+	keepaliveInterval time.Duration // 为 0 时服务端不主动发送探测帧
+	keepaliveTimeout  time.Duration
+
+	inflight   atomic.Int64 // 正在处理的请求数，随每个响应的 Header.Load 返回给客户端
+	load       LoadReporter // 不为空时同时以 Addr 为节点名上报正在处理的请求数
+	replicator *Replicator  // 不为空时把写操作转发到副本节点
 }
 
 func NewServer(gee *Group, id string, addr string) *Server {
@@ -53,6 +79,24 @@ func NewServer(gee *Group, id string, addr string) *Server {
 	}
 }
 
+// SetAuth 设置握手阶段使用的认证方式和请求的授权策略，需要在 Accept 之前调用。
+func (server *Server) SetAuth(auth Authenticator, policy Policy) {
+	server.auth = auth
+	server.policy = policy
+}
+
+// SetLoadReporter 设置负载上报的目标，需要在 Accept 之前调用。
+// 正在处理的请求数总是随响应返回给客户端，API 服务器据此校准哈希环上的负载（参见 APIServer.SetLoadReporter）；
+// r 用于同一进程中直接使用该节点负载的哈希环。
+func (server *Server) SetLoadReporter(r LoadReporter) {
+	server.load = r
+}
+
+// SetReplicator 设置转发写操作使用的 Replicator，group 的副本数由 Group.SetReplication 设置，需要在 Accept 之前调用。
+func (server *Server) SetReplicator(r *Replicator) {
+	server.replicator = r
+}
+
 // DefaultServer 是一个默认的 Server 实例，主要为了用户使用方便。
 // var DefaultServer = NewServer()
 
@@ -71,6 +115,12 @@ func (server *Server) Accept(lis net.Listener) {
 	}
 }
 
+// AcceptTLS 在 lis 之上使用 config 完成 TLS 握手后再处理连接。
+// config 中设置了 ClientCAs 和 ClientAuth 时即为双向认证（mTLS），可由 NewServerTLSConfig 创建。
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
 const (
 	connected        = "200 Connected to Gee RPC"
 	defaultRPCPath   = "/_geeprc_"
@@ -100,10 +150,18 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		_ = conn.Close()
 	}()
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
+	// json.Decoder 会预读 Option 之后的数据，需要把这部分数据放回读取流中，否则会丢失紧随其后的 Header。
+	// 客户端使用 json.Encoder 发送 Option，末尾的换行符不属于后续的 gob 数据，需要跳过。
+	br := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := br.ReadByte(); err == nil && b != '\n' {
+		_ = br.UnreadByte()
+	}
+	conn = &handshakeConn{r: br, ReadWriteCloser: conn}
 	if opt.MagicNumber != MagicNumber {
 		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
 		return
@@ -114,7 +172,41 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
 		return
 	}
-	server.ServeCodec(f(conn), &opt)
+	// 认证通过后，opt.Identity 即为该连接上所有请求的身份；未启用认证时为匿名身份
+	identity := ""
+	if server.auth != nil {
+		id, err := server.auth.Authenticate(&opt)
+		if err != nil {
+			log.Printf("rpc server: authenticate %q error: %v", opt.Identity, err)
+			// 关闭连接之前告诉客户端认证失败的原因
+			h := &codec.Header{}
+			setError(h, err)
+			_ = f(conn).Write(h, invalidRequest)
+			return
+		}
+		identity = id
+	}
+	opt.Identity = identity
+	server.ServeCodec(newCodec(f, conn, &opt), &opt)
+}
+
+// newCodec 创建编解码器，opt.WriteLinger 大于 0 且编解码器支持时开启批量写。
+func newCodec(f codec.NewCodecFunc, conn io.ReadWriteCloser, opt *Option) codec.Codec {
+	cc := f(conn)
+	if bw, ok := cc.(codec.BatchWriter); ok && opt.WriteLinger > 0 {
+		bw.EnableBatching(opt.WriteLinger)
+	}
+	return cc
+}
+
+// handshakeConn 先读取握手时预读的数据，再继续从原连接中读取。
+type handshakeConn struct {
+	r io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
 }
 
 // 当出错时作为响应函数的参数，表示请求不合法。
@@ -123,19 +215,50 @@ var invalidRequest = struct{}{}
 func (server *Server) ServeCodec(cc codec.Codec, opt *Option) {
 	sending := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
+	// lastRecv 记录最近一次收到客户端数据的时间，用于 keepalive 判断客户端是否失联
+	lastRecv := new(atomic.Int64)
+	lastRecv.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	defer close(done)
+	server.startKeepalive(cc, lastRecv, done, sending)
+	windows := newStreamWindows(opt.StreamWindow)
 	for {
 		req, err := server.readRequest(cc) // 读取请求
+		if req != nil || err == nil {
+			lastRecv.Store(time.Now().UnixNano())
+		}
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.h.Error = err.Error()
+			setError(req.h, err)
 			server.sendResponse(cc, req.h, invalidRequest, sending) // 回复请求
 			continue
 		}
+		if req.h.Ping {
+			server.sendResponse(cc, &codec.Header{Pong: true}, req.argv.Interface(), sending)
+			continue
+		}
+		if req.h.Pong {
+			continue
+		}
+		if req.h.Ack {
+			windows.ack(req.h.Seq, req.argv.Interface().(int64))
+			continue
+		}
+		req.identity = opt.Identity
+		req.streaming = opt.Streaming
+		req.windows = windows
+		if err := server.authorize(req); err != nil {
+			setError(req.h, err)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			continue
+		}
 		wg.Add(1)
 		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout) // 处理请求
 	}
+	// 不会再收到确认帧，结束所有等待窗口的流式响应
+	windows.close()
 	wg.Wait()
 	_ = cc.Close()
 }
@@ -144,6 +267,10 @@ type request struct {
 	h            *codec.Header
 	argv, replyv reflect.Value
 	method       string
+	group        *Group         // 请求访问的 group，由 Header.Group 决定
+	identity     string         // 认证后的调用方身份
+	streaming    bool           // 客户端能否接收流式响应
+	windows      *streamWindows // 连接上流式响应的发送窗口，为空时不做流量控制
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
@@ -158,40 +285,115 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 }
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
+	server.inflight.Add(1)
+	defer server.inflight.Add(-1)
+	if server.load != nil {
+		server.load.Inc(server.Addr)
+		defer server.load.Done(server.Addr)
+	}
 
 	called := make(chan struct{})
 	sent := make(chan struct{})
 	go func() {
 		var err error
+		var stream io.Reader // 不为空时以流式响应发送
 		switch req.method {
 		case "Group.Get":
 			key := *req.argv.Interface().(*string)
-			value, err := server.gee.Get(key)
-			if err == nil {
+			var value ByteView
+			value, err = req.group.Get(key)
+			if err == nil && req.streaming && value.Len() > streamThreshold {
+				// 较大的值分帧发送，避免一次编码整个值并长时间占用连接
+				stream = bytes.NewReader(value.b)
+			} else if err == nil {
 				req.replyv.Elem().Set(reflect.ValueOf(string(value.ByteSlice())))
 			}
+		case "Group.GetStream":
+			key := *req.argv.Interface().(*string)
+			var value ByteView
+			value, err = req.group.Get(key)
+			if err == nil {
+				stream = bytes.NewReader(value.b)
+			}
+		case "Group.Dump":
+			prefix := *req.argv.Interface().(*string)
+			stream = req.group.dump(prefix)
 		case "Group.Insert":
 			kv := *req.argv.Interface().(*[2]string)
-			value := ByteView{b: []byte(kv[1])}
-			server.gee.Insert(kv[0], value)
+			value := req.group.insert(kv[0], ByteView{b: []byte(kv[1])})
 			*req.replyv.Interface().(*string) = "Insert successful"
+			err = server.replicator.put(req.group, kv[0], value)
 		case "Group.Delete":
 			key := *req.argv.Interface().(*string)
-			err = server.gee.Delete(key)
+			var dead ByteView
+			dead, err = req.group.delete(key)
 			if err == nil {
 				*req.replyv.Interface().(*string) = "Delete successful"
 			} else {
 				*req.replyv.Interface().(*string) = "Delete failed"
 			}
+			// 本地不存在时副本上仍然可能存在，同样需要把删除标记复制到副本
+			if rerr := server.replicator.put(req.group, key, dead); rerr != nil && err == nil {
+				err = rerr
+			}
+		case "Group.GetVersioned":
+			// 只读取本地缓存，不从数据源加载：quorum 读比较的是各个副本上写入的版本，
+			// 从数据源加载的值没有版本号，会掩盖副本之间的差异。已经删除时返回删除标记
+			key := *req.argv.Interface().(*string)
+			reply := req.replyv.Interface().(*VersionedValue)
+			*reply = VersionedValue{Key: key}
+			if value, ok := req.group.lookupVersioned(key); ok {
+				*reply = versionedValue(key, value)
+			}
+		case "Group.Load":
+			key := *req.argv.Interface().(*string)
+			reply := req.replyv.Interface().(*VersionedValue)
+			var value ByteView
+			if value, err = req.group.Get(key); err == nil {
+				*reply = versionedValue(key, value)
+			} else if errors.Is(err, ErrNotFound) {
+				*reply, err = VersionedValue{Key: key}, nil
+			}
+		case "Group.Put":
+			v := *req.argv.Interface().(*VersionedValue)
+			if _, err = req.group.put(v.Key, v.view()); err == nil {
+				*req.replyv.Interface().(*string) = "Put successful"
+			}
+		case "Group.CompareAndSwap", "Group.InsertIfAbsent":
+			v := *req.argv.Interface().(*VersionedValue)
+			var value ByteView
+			if req.method == "Group.CompareAndSwap" {
+				value, err = req.group.CompareAndSwap(v.Key, v.Version, ByteView{b: []byte(v.Value)})
+			} else {
+				value, err = req.group.InsertIfAbsent(v.Key, ByteView{b: []byte(v.Value)})
+			}
+			if err == nil {
+				*req.replyv.Interface().(*VersionedValue) = versionedValue(v.Key, value)
+				err = server.replicator.put(req.group, v.Key, value)
+			}
+		case "Group.DeleteIfVersion":
+			v := *req.argv.Interface().(*VersionedValue)
+			var dead ByteView
+			if dead, err = req.group.deleteIfVersion(v.Key, v.Version); err == nil {
+				*req.replyv.Interface().(*VersionedValue) = versionedValue(v.Key, dead)
+				err = server.replicator.put(req.group, v.Key, dead)
+			}
 		}
 		called <- struct{}{}
 		if err != nil {
 			log.Println("rpc server: operator error ", err)
-			req.h.Error = err.Error()
+			setError(req.h, err)
 			server.sendResponse(cc, req.h, invalidRequest, sending)
 			sent <- struct{}{}
 			return
 		}
+		if stream != nil {
+			w := req.windows.open(req.h.Seq)
+			server.sendStream(cc, req.h, stream, sending, func() bool { return req.windows.acquire(w) })
+			req.windows.release(req.h.Seq)
+			sent <- struct{}{}
+			return
+		}
 		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
 		sent <- struct{}{}
 	}()
@@ -203,20 +405,34 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 
 	select {
 	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout, expect within %s", timeout)
+		setError(req.h, fmt.Errorf("rpc server: request handle timeout, expect within %s: %w", timeout, context.DeadlineExceeded))
 		server.sendResponse(cc, req.h, invalidRequest, sending)
 	case <-called:
 		<-sent
 	}
 }
+
 func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
+	// keepalive 探测帧和流式响应的确认帧没有对应的方法，Body 是发送时的时间戳或者确认的帧数
+	if h.Ping || h.Pong || h.Ack {
+		var timestamp int64
+		if err := cc.ReadBody(&timestamp); err != nil {
+			return nil, err
+		}
+		return &request{h: h, argv: reflect.ValueOf(timestamp)}, nil
+	}
 	req := &request{
 		h:      h,
 		method: h.ServiceMethod,
+		group:  server.gee,
+	}
+	// Header.Group 为空时访问服务器默认的 group
+	if h.Group != "" {
+		req.group = GetGroup(h.Group)
 	}
 
 	switch req.method {
@@ -226,9 +442,21 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	case "Group.Insert":
 		req.argv = reflect.ValueOf(new([2]string)) // 创建 *[2]string 类型的指针
 		req.replyv = reflect.ValueOf(new(string))  // 创建 *bool 类型的指针
+	case "Group.GetVersioned", "Group.Load":
+		req.argv = reflect.ValueOf(new(string))
+		req.replyv = reflect.ValueOf(new(VersionedValue))
+	case "Group.Put":
+		req.argv = reflect.ValueOf(new(VersionedValue))
+		req.replyv = reflect.ValueOf(new(string))
+	case "Group.CompareAndSwap", "Group.InsertIfAbsent", "Group.DeleteIfVersion":
+		req.argv = reflect.ValueOf(new(VersionedValue)) // Version 是 CompareAndSwap 期望的版本号
+		req.replyv = reflect.ValueOf(new(VersionedValue))
 	case "Group.Delete":
 		req.argv = reflect.ValueOf(new(string))   // 创建 *string 类型的指针
 		req.replyv = reflect.ValueOf(new(string)) // 创建 *bool 类型的指针
+	case "Group.GetStream", "Group.Dump":
+		req.argv = reflect.ValueOf(new(string))   // key 或者 key 的前缀
+		req.replyv = reflect.ValueOf(new(string)) // 结果以流式帧发送，不使用 replyv
 	default:
 		return nil, errors.New("rpc server: unknown method " + req.method)
 	}
@@ -238,12 +466,18 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		log.Println("rpc server: read argv err:", err)
 		return req, err
 	}
+	if req.group == nil {
+		return req, fmt.Errorf("%w %s", ErrNoSuchGroup, h.Group)
+	}
 	return req, nil
 }
 
 func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
+	if !h.Pong {
+		h.Load = server.inflight.Load()
+	}
 	if err := cc.Write(h, body); err != nil {
 		log.Println("rpc server: write response error: ", err)
 	}
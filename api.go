@@ -0,0 +1,581 @@
+package distributecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIServer 为用户提供 REST 接口，根据 key 找到所属节点，通过连接池中的 RPC 客户端转发请求：
+//
+//	GET    /v1/groups/{group}/keys/{key}  获取缓存值，支持 If-None-Match
+//	PUT    /v1/groups/{group}/keys/{key}  写入缓存值，请求体即为 value
+//	DELETE /v1/groups/{group}/keys/{key}  删除缓存
+//	POST   /v1/groups/{group}/batch/get     {"keys": ["k1", "k2"]}
+//	POST   /v1/groups/{group}/batch/put     {"items": {"k1": "v1"}}
+//	POST   /v1/groups/{group}/batch/delete  {"keys": ["k1", "k2"]}
+//	GET    /v1/nodes                        集群中所有节点注册的信息
+//
+// 读写接口可以通过 ?consistency=one|quorum|all 或者请求头 X-Consistency 指定一致性级别，
+// 此时由 API 服务器直接读写 key 的所有副本，读取时返回版本号最大的值并修复旧的副本。
+// 不指定时只访问主节点，由主节点转发写操作。
+// 每个缓存值都带有写入时由混合逻辑时钟分配的版本号（参见 HLC），读取时通过响应头 X-Cache-Version 返回。
+// PUT 和 DELETE 支持条件写入：If-None-Match: * 表示 key 不存在时才写入，?if-version= 或者请求头 X-If-Version
+// 表示 key 当前的版本号等于它时才写入或删除。条件不满足时返回 409，响应头 X-Cache-Version 是 key 当前的版本号。
+type APIServer struct {
+	peers   PeerPicker  // 根据 key 选择节点的 RPC 地址
+	clients *ClientPool // 到各个节点的 RPC 客户端
+	timeout time.Duration
+	mux     *http.ServeMux
+
+	load LoadReporter // 不为空时上报转发到各个节点的请求数
+
+	mu       sync.RWMutex
+	nodes    map[string]Member // 节点注册的信息，键是节点的地址，由 SetNodes 更新
+	replicas map[string]int    // 各个 group 的副本数，由 SetReplicas 设置
+}
+
+const (
+	apiPrefix         = "/v1/groups/"
+	defaultAPITimeout = time.Second * 5
+	maxValueBytes     = 64 << 20 // PUT 请求体的最大长度
+
+	maxBatchConcurrency = 32 // 一个批量请求同时转发到节点的调用数
+)
+
+func NewAPIServer(peers PeerPicker, clients *ClientPool) *APIServer {
+	s := &APIServer{
+		peers:   peers,
+		clients: clients,
+		timeout: defaultAPITimeout,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET "+apiPrefix+"{group}/keys/{key...}", s.handleGet)
+	s.mux.HandleFunc("PUT "+apiPrefix+"{group}/keys/{key...}", s.handlePut)
+	s.mux.HandleFunc("DELETE "+apiPrefix+"{group}/keys/{key...}", s.handleDelete)
+	s.mux.HandleFunc("POST "+apiPrefix+"{group}/batch/get", s.handleBatchGet)
+	s.mux.HandleFunc("POST "+apiPrefix+"{group}/batch/put", s.handleBatchPut)
+	s.mux.HandleFunc("POST "+apiPrefix+"{group}/batch/delete", s.handleBatchDelete)
+	s.mux.HandleFunc("GET /v1/nodes", s.handleNodes)
+	return s
+}
+
+// SetLoadReporter 设置负载上报的目标，通常是开启了有界负载的哈希环，需要在开始处理请求之前调用。
+func (s *APIServer) SetLoadReporter(r LoadReporter) {
+	s.load = r
+}
+
+// SetNodes 更新节点信息，实现 NodeSetter。
+func (s *APIServer) SetNodes(members []Member) {
+	nodes := make(map[string]Member, len(members))
+	for _, m := range members {
+		nodes[m.Addr] = m
+	}
+	s.mu.Lock()
+	s.nodes = nodes
+	s.mu.Unlock()
+}
+
+// node 返回地址为 addr 的节点信息。
+func (s *APIServer) node(addr string) (Member, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.nodes[addr]
+	return m, ok
+}
+
+func (s *APIServer) handleNodes(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	nodes := make([]Member, 0, len(s.nodes))
+	for _, m := range s.nodes {
+		nodes = append(nodes, m)
+	}
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sortMembers(nodes)); err != nil {
+		log.Println("api server: encode nodes error:", err)
+	}
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+var (
+	errNoPeer           = errors.New("no node available for key")
+	errCodecUnsupported = errors.New("node does not support the codec used by the api server")
+)
+
+// call 把一次读请求转发到 key 所属的节点。开启有界负载时节点可能是负载未满的后继节点，
+// 后继节点的缓存中没有该 key 时从数据源加载。
+func (s *APIServer) call(ctx context.Context, group, key, serviceMethod string, args, reply interface{}) error {
+	rpcAddr := s.peers.PickPeer(key)
+	if rpcAddr == "" {
+		return errNoPeer
+	}
+	return s.callNode(ctx, rpcAddr, group, serviceMethod, args, reply)
+}
+
+// owner 返回 key 的主节点，不受有界负载的影响。
+func (s *APIServer) owner(key string) string {
+	if picker, ok := s.peers.(ReplicaPicker); ok {
+		if addrs := picker.PickPeers(key, 1); len(addrs) > 0 {
+			return addrs[0]
+		}
+		return ""
+	}
+	return s.peers.PickPeer(key)
+}
+
+// callOwner 把一次写请求转发到 key 的主节点。写操作和条件写必须由同一个节点执行，
+// 不能像读请求一样溢出到其他节点，否则同一个 key 的值会分散在多个节点上，版本号比较也会失效。
+func (s *APIServer) callOwner(ctx context.Context, group, key, serviceMethod string, args, reply interface{}) error {
+	rpcAddr := s.owner(key)
+	if rpcAddr == "" {
+		return errNoPeer
+	}
+	return s.callNode(ctx, rpcAddr, group, serviceMethod, args, reply)
+}
+
+// callNode 把一次调用转发到节点 rpcAddr。
+func (s *APIServer) callNode(ctx context.Context, rpcAddr, group, serviceMethod string, args, reply interface{}) error {
+	// 节点注册了支持的编解码方式时，只在双方都支持时才发起调用
+	if m, ok := s.node(rpcAddr); ok && len(m.Codecs) > 0 && !slices.Contains(m.Codecs, string(s.clients.codecType())) {
+		return fmt.Errorf("%s: %w", rpcAddr, errCodecUnsupported)
+	}
+	client, err := s.clients.Get(rpcAddr)
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", rpcAddr, err)
+	}
+	if s.load != nil {
+		s.load.Inc(rpcAddr)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	err = client.CallGroup(ctx, group, serviceMethod, args, reply)
+	if s.load != nil {
+		s.load.Done(rpcAddr)
+		// 节点上报的负载包括本次调用，本次调用已经完成，因此减一
+		if u, ok := s.load.(LoadUpdater); ok && err == nil {
+			u.UpdateLoad(rpcAddr, max(client.serverLoad()-1, 0))
+		}
+	}
+	return err
+}
+
+// SetReplicas 设置 group 的副本数，与节点上 Group.SetReplication 的 Factor 相同。
+// 副本数大于 1 且 peers 实现了 ReplicaPicker 时，主节点不可达的读请求依次尝试其余副本。
+func (s *APIServer) SetReplicas(group string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replicas == nil {
+		s.replicas = make(map[string]int)
+	}
+	s.replicas[group] = n
+}
+
+// get 读取 key 的值，主节点不可达时依次尝试其余副本节点。
+func (s *APIServer) get(ctx context.Context, group, key string, value *VersionedValue) error {
+	s.mu.RLock()
+	n := s.replicas[group]
+	s.mu.RUnlock()
+	picker, ok := s.peers.(ReplicaPicker)
+	if n <= 1 || !ok {
+		return found(key, value, s.call(ctx, group, key, "Group.Load", &key, value))
+	}
+	addrs := picker.PickPeers(key, n)
+	if len(addrs) == 0 {
+		return errNoPeer
+	}
+	var err error
+	for _, addr := range addrs {
+		if err = s.callNode(ctx, addr, group, "Group.Load", &key, value); !unreachable(err) {
+			return found(key, value, err)
+		}
+		log.Printf("api server: read %s from %s failed, trying next replica: %v", key, addr, err)
+	}
+	return err
+}
+
+// read 按一致性级别 level 读取 key，level 为 0 时只访问主节点，主节点不可达时尝试其余副本。
+func (s *APIServer) read(ctx context.Context, group, key string, level Consistency) (VersionedValue, error) {
+	if level != 0 {
+		return s.quorumGet(ctx, group, key, level)
+	}
+	var value VersionedValue
+	if err := s.get(ctx, group, key, &value); err != nil {
+		return VersionedValue{}, err
+	}
+	return value, nil
+}
+
+// found 把 Group.Load 返回的不存在的结果转换为 ErrNotFound。
+func found(key string, value *VersionedValue, err error) error {
+	if err == nil && !value.Found {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return err
+}
+
+// write 按一致性级别 level 写入 key，返回写入的版本号，level 为 0 时写入主节点，版本号由主节点分配，返回 0。
+func (s *APIServer) write(ctx context.Context, group, key, value string, level Consistency) (uint64, error) {
+	if level != 0 {
+		v, err := s.quorumPut(ctx, group, key, value, level)
+		return v.Version, err
+	}
+	var reply string
+	return 0, s.callOwner(ctx, group, key, "Group.Insert", [2]string{key, value}, &reply)
+}
+
+// remove 按一致性级别 level 删除 key，level 为 0 时由主节点删除并转发到副本。
+func (s *APIServer) remove(ctx context.Context, group, key string, level Consistency) error {
+	if level != 0 {
+		return s.quorumDelete(ctx, group, key, level)
+	}
+	var reply string
+	return s.callOwner(ctx, group, key, "Group.Delete", &key, &reply)
+}
+
+// precondition 是条件写入的前置条件。
+type precondition struct {
+	absent  bool   // key 不存在时才写入
+	version uint64 // key 当前的版本号等于 version 时才写入或删除
+}
+
+// writeIf 由主节点在前置条件 cond 满足时写入 key，返回写入的版本号。
+func (s *APIServer) writeIf(ctx context.Context, group, key, value string, cond *precondition) (uint64, error) {
+	method := "Group.CompareAndSwap"
+	if cond.absent {
+		method = "Group.InsertIfAbsent"
+	}
+	var reply VersionedValue
+	err := s.callOwner(ctx, group, key, method, &VersionedValue{Key: key, Value: value, Version: cond.version}, &reply)
+	return reply.Version, err
+}
+
+// removeIf 由主节点在 key 当前的版本号等于 cond.version 时删除 key。
+func (s *APIServer) removeIf(ctx context.Context, group, key string, cond *precondition) error {
+	var reply VersionedValue
+	return s.callOwner(ctx, group, key, "Group.DeleteIfVersion", &VersionedValue{Key: key, Version: cond.version}, &reply)
+}
+
+// conditional 读取请求指定的前置条件：If-None-Match: * 表示 key 不存在时才写入，
+// ?if-version= 或者请求头 X-If-Version 表示 key 的版本号等于它时才写入或删除。
+// 没有指定时返回 nil；格式错误，或者与一致性级别 level 同时指定时返回 400 并返回 false，
+// 条件写入只能由主节点原子地检查并写入。
+func conditional(w http.ResponseWriter, r *http.Request, level Consistency) (*precondition, bool) {
+	v := r.URL.Query().Get("if-version")
+	if v == "" {
+		v = r.Header.Get("X-If-Version")
+	}
+	absent := r.Method == http.MethodPut && r.Header.Get("If-None-Match") == "*"
+	if v == "" && !absent {
+		return nil, true
+	}
+	cond := &precondition{absent: absent}
+	var err error
+	switch {
+	case v != "" && absent:
+		err = errors.New("If-None-Match: * cannot be combined with if-version")
+	case level != 0:
+		err = errors.New("conditional writes cannot be combined with consistency")
+	case v != "":
+		if cond.version, err = strconv.ParseUint(v, 10, 64); err != nil {
+			err = fmt.Errorf("invalid if-version %q", v)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return cond, true
+}
+
+// conflict 在前置条件不满足时通过响应头 X-Cache-Version 返回 key 当前的版本号。
+func conflict(w http.ResponseWriter, err error) {
+	if version, ok := ConflictVersion(err); ok {
+		setVersion(w, version)
+	}
+}
+
+// consistency 读取请求指定的一致性级别，没有指定时返回 0，格式错误时返回 400 并返回 false。
+func consistency(w http.ResponseWriter, r *http.Request) (Consistency, bool) {
+	v := r.URL.Query().Get("consistency")
+	if v == "" {
+		v = r.Header.Get("X-Consistency")
+	}
+	if v == "" {
+		return 0, true
+	}
+	level, err := ParseConsistency(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return level, true
+}
+
+// setVersion 在响应头中返回值的版本号，版本号未知时不设置。
+func setVersion(w http.ResponseWriter, version uint64) {
+	if version != 0 {
+		w.Header().Set("X-Cache-Version", strconv.FormatUint(version, 10))
+	}
+}
+
+// unreachable 判断调用失败是否是因为节点不可达，节点返回的错误（例如 key 不存在）不需要尝试其他副本。
+func unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := statusCode(err)
+	return code == http.StatusBadGateway || code == http.StatusGatewayTimeout || code == http.StatusServiceUnavailable
+}
+
+// statusCode 把转发过程中的错误转换为 HTTP 状态码，节点返回的错误通过 RPC 响应中的错误码识别（参见 RemoteError）。
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errNoPeer), errors.Is(err, errCodecUnsupported), errors.Is(err, ErrQuorum):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrNoSuchGroup):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// etagMatch 判断 If-None-Match 请求头 header 是否与 tag 匹配。header 可以是 *，
+// 也可以是以逗号分隔的多个 ETag，按照 RFC 9110 使用弱比较，即忽略 W/ 前缀。
+func etagMatch(header, tag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// etag 根据缓存值生成强校验的 ETag。
+func etag(value string) string {
+	h := fnv.New64a()
+	io.WriteString(h, value)
+	return fmt.Sprintf("\"%016x\"", h.Sum64())
+}
+
+func (s *APIServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	group, key := r.PathValue("group"), r.PathValue("key")
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	v, err := s.read(r.Context(), group, key, level)
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	value := v.Value
+	setVersion(w, v.Version)
+	tag := etag(value)
+	w.Header().Set("ETag", tag)
+	if etagMatch(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.WriteString(w, value)
+}
+
+func (s *APIServer) handlePut(w http.ResponseWriter, r *http.Request) {
+	group, key := r.PathValue("group"), r.PathValue("key")
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueBytes))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	cond, ok := conditional(w, r, level)
+	if !ok {
+		return
+	}
+	var version uint64
+	if cond != nil {
+		version, err = s.writeIf(r.Context(), group, key, string(value), cond)
+	} else {
+		version, err = s.write(r.Context(), group, key, string(value), level)
+	}
+	if err != nil {
+		conflict(w, err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	setVersion(w, version)
+	w.Header().Set("ETag", etag(string(value)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	group, key := r.PathValue("group"), r.PathValue("key")
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	cond, ok := conditional(w, r, level)
+	if !ok {
+		return
+	}
+	var err error
+	if cond != nil {
+		err = s.removeIf(r.Context(), group, key, cond)
+	} else {
+		err = s.remove(r.Context(), group, key, level)
+	}
+	if err != nil {
+		conflict(w, err)
+		http.Error(w, err.Error(), statusCode(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// batchRequest 是批量接口的请求体，batch/get 和 batch/delete 使用 Keys，batch/put 使用 Items。
+type batchRequest struct {
+	Keys  []string          `json:"keys,omitempty"`
+	Items map[string]string `json:"items,omitempty"`
+}
+
+// batchResult 是批量接口中单个 key 的结果。
+type batchResult struct {
+	Value   string `json:"value,omitempty"`
+	ETag    string `json:"etag,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// batch 并发地把每个 key 的调用转发到所属节点，同时进行的调用不超过 maxBatchConcurrency 个，
+// 所有调用结束后以 JSON 返回每个 key 的结果。
+func (s *APIServer) batch(w http.ResponseWriter, r *http.Request, keys []string, fn func(key string) batchResult) {
+	results := make(map[string]batchResult, len(keys))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxBatchConcurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := fn(key)
+			mu.Lock()
+			results[key] = res
+			mu.Unlock()
+		}(key)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"results": results}); err != nil {
+		log.Println("api server: encode batch response error:", err)
+	}
+}
+
+func readBatchRequest(w http.ResponseWriter, r *http.Request) (*batchRequest, bool) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValueBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid batch request: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+func errorResult(err error) batchResult {
+	return batchResult{Status: statusCode(err), Error: err.Error()}
+}
+
+func (s *APIServer) handleBatchGet(w http.ResponseWriter, r *http.Request) {
+	req, ok := readBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	group := r.PathValue("group")
+	s.batch(w, r, req.Keys, func(key string) batchResult {
+		v, err := s.read(r.Context(), group, key, level)
+		if err != nil {
+			return errorResult(err)
+		}
+		return batchResult{Value: v.Value, ETag: etag(v.Value), Version: v.Version, Status: http.StatusOK}
+	})
+}
+
+func (s *APIServer) handleBatchPut(w http.ResponseWriter, r *http.Request) {
+	req, ok := readBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	group := r.PathValue("group")
+	keys := make([]string, 0, len(req.Items))
+	for key := range req.Items {
+		keys = append(keys, key)
+	}
+	s.batch(w, r, keys, func(key string) batchResult {
+		value := req.Items[key]
+		version, err := s.write(r.Context(), group, key, value, level)
+		if err != nil {
+			return errorResult(err)
+		}
+		return batchResult{ETag: etag(value), Version: version, Status: http.StatusNoContent}
+	})
+}
+
+func (s *APIServer) handleBatchDelete(w http.ResponseWriter, r *http.Request) {
+	req, ok := readBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	level, ok := consistency(w, r)
+	if !ok {
+		return
+	}
+	group := r.PathValue("group")
+	s.batch(w, r, req.Keys, func(key string) batchResult {
+		if err := s.remove(r.Context(), group, key, level); err != nil {
+			return errorResult(err)
+		}
+		return batchResult{Status: http.StatusNoContent}
+	})
+}
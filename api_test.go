@@ -0,0 +1,81 @@
+package distributecache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatusCodeFromRPC(t *testing.T) {
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		if key == "broken" {
+			// 错误信息中包含 key not found，但不是 ErrNotFound
+			return nil, errors.New("backend says: key not found in shard map")
+		}
+		return nil, ErrNotFound
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := "tcp@" + lis.Addr().String()
+	go NewServer(g, t.Name(), addr).Accept(lis)
+	client, err := XDial(addr, &Option{ConnectTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	call := func(group, key string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var reply string
+		return client.CallGroup(ctx, group, "Group.Get", &key, &reply)
+	}
+	tests := []struct {
+		group, key string
+		want       int
+	}{
+		{t.Name(), "missing", http.StatusNotFound},
+		{t.Name(), "broken", http.StatusBadGateway},
+		{"no-such-group", "k", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		err := call(tt.group, tt.key)
+		if err == nil {
+			t.Fatalf("%s/%s: expected an error", tt.group, tt.key)
+		}
+		if got := statusCode(err); got != tt.want {
+			t.Errorf("%s/%s: statusCode(%v) = %d, want %d", tt.group, tt.key, err, got, tt.want)
+		}
+	}
+	if err := call(t.Name(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("errors.Is(%v, ErrNotFound) = false", err)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	tag := `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`*`, true},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x",W/"abc" `, true},
+		{`"x", "y"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, tag); got != tt.want {
+			t.Errorf("etagMatch(%q, %q) = %v, want %v", tt.header, tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package distributecache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 认证发生在 Option 握手阶段：客户端在 Option 中携带 Identity 和 Token，
+// 服务端通过 Authenticator 校验后得到可信的身份，该连接上的每个请求都带有这个身份，
+// 再由 Policy 判断该身份能否在某个 group 上调用某个方法。
+// 认证失败时服务端在关闭连接之前发送一个 Seq 为 0 的响应，Header.Error 为认证错误，
+// 客户端据此结束所有等待中的调用，而不是只看到连接被关闭。
+
+var (
+	ErrUnauthenticated  = errors.New("rpc server: unauthenticated")
+	ErrPermissionDenied = errors.New("rpc server: permission denied")
+)
+
+// Authenticator 校验握手时客户端发送的 Option，返回认证后的身份。
+type Authenticator interface {
+	Authenticate(opt *Option) (identity string, err error)
+}
+
+// TokenAuthenticator 是基于 bearer token 的认证方式，键是 token，值是 token 对应的身份。
+type TokenAuthenticator map[string]string
+
+func (a TokenAuthenticator) Authenticate(opt *Option) (string, error) {
+	identity, ok := a[opt.Token]
+	if opt.Token == "" || !ok {
+		return "", ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+const defaultMaxSkew = time.Minute * 5
+
+// HMACAuthenticator 是基于共享密钥的认证方式。
+// 客户端使用 SignOption 对 Identity、Timestamp 和随机的 Nonce 签名，服务端使用同一个 Secret 校验签名，
+// 并拒绝时间戳与本地时间相差超过 MaxSkew 的握手。服务端记住 MaxSkew 时间窗口内用过的 Nonce，
+// 同一个签名只能用于建立一个连接，截获的握手无法被重放。
+type HMACAuthenticator struct {
+	Secret  []byte
+	MaxSkew time.Duration // 为 0 时使用 defaultMaxSkew
+
+	mu   sync.Mutex
+	seen map[string]time.Time // 时间窗口内用过的 Nonce 及其时间戳
+}
+
+func (a *HMACAuthenticator) Authenticate(opt *Option) (string, error) {
+	if opt.Identity == "" || opt.Token == "" || opt.Nonce == "" {
+		return "", ErrUnauthenticated
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+	ts := time.Unix(opt.Timestamp, 0)
+	skew := time.Since(ts)
+	if skew > maxSkew || skew < -maxSkew {
+		return "", fmt.Errorf("%w: timestamp out of range", ErrUnauthenticated)
+	}
+	expected := signature(a.Secret, opt.Identity, opt.Timestamp, opt.Nonce)
+	if !hmac.Equal([]byte(expected), []byte(opt.Token)) {
+		return "", fmt.Errorf("%w: bad signature", ErrUnauthenticated)
+	}
+	if !a.remember(opt.Nonce, ts, maxSkew) {
+		return "", fmt.Errorf("%w: replayed handshake", ErrUnauthenticated)
+	}
+	return opt.Identity, nil
+}
+
+// remember 记录 nonce，nonce 已经用过时返回 false。超出时间窗口的 nonce 无法通过时间戳检查，可以丢弃。
+func (a *HMACAuthenticator) remember(nonce string, ts time.Time, maxSkew time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+	if _, ok := a.seen[nonce]; ok {
+		return false
+	}
+	now := time.Now()
+	for n, t := range a.seen {
+		if now.Sub(t) > maxSkew {
+			delete(a.seen, n)
+		}
+	}
+	a.seen[nonce] = ts
+	return true
+}
+
+// SignOption 使用共享密钥为 opt 生成 HMAC 签名，配合服务端的 HMACAuthenticator 使用。
+// 每次调用都会生成新的 Nonce，因此每建立一个连接都需要重新签名。
+func SignOption(opt *Option, identity string, secret []byte) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	opt.Identity = identity
+	opt.Timestamp = time.Now().Unix()
+	opt.Nonce = hex.EncodeToString(nonce)
+	opt.Token = signature(secret, identity, opt.Timestamp, opt.Nonce)
+}
+
+func signature(secret []byte, identity string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(identity + "|" + strconv.FormatInt(timestamp, 10) + "|" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Policy 判断身份 identity 能否在 group 上调用 method。
+type Policy interface {
+	Allow(identity, method, group string) bool
+}
+
+// Rule 是一条授权规则，字段为 "*" 时匹配任意值。
+type Rule struct {
+	Identity string
+	Method   string
+	Group    string
+}
+
+func (r Rule) match(identity, method, group string) bool {
+	return (r.Identity == "*" || r.Identity == identity) &&
+		(r.Method == "*" || r.Method == method) &&
+		(r.Group == "*" || r.Group == group)
+}
+
+// ACL 是由若干 Rule 组成的白名单，任意一条规则匹配即允许调用。
+type ACL []Rule
+
+func (acl ACL) Allow(identity, method, group string) bool {
+	for _, r := range acl {
+		if r.match(identity, method, group) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ Policy = ACL(nil)
+
+// peerMethods 是节点之间以及 API 服务器使用的方法。Group.Put 按调用方给出的版本号写入，
+// 客户端可以调用时能够伪造一个很大的版本号，使之后对该 key 的所有写入都被拒绝。
+var peerMethods = []string{"Group.Put"}
+
+// PeerPolicy 只允许 Peers 中的身份调用节点之间使用的方法（Group.Put），其他方法交给 Next 判断，Next 为空时允许。
+// 身份由 Authenticator 认证得到：使用共享密钥的 HMACAuthenticator 时，持有密钥的客户端可以声明任意身份，
+// 需要区分时为节点和客户端使用不同的 token（TokenAuthenticator）。
+type PeerPolicy struct {
+	Peers []string
+	Next  Policy
+}
+
+func (p *PeerPolicy) Allow(identity, method, group string) bool {
+	if slices.Contains(peerMethods, method) && !slices.Contains(p.Peers, identity) {
+		return false
+	}
+	return p.Next == nil || p.Next.Allow(identity, method, group)
+}
+
+var _ Policy = (*PeerPolicy)(nil)
+
+// authorize 检查请求的身份是否有权限调用对应的方法，未设置 Policy 时允许所有调用。
+func (server *Server) authorize(req *request) error {
+	if server.policy == nil || server.policy.Allow(req.identity, req.method, req.group.name) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q cannot call %s on group %s", ErrPermissionDenied, req.identity, req.method, req.group.name)
+}
@@ -0,0 +1,131 @@
+package distributecache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startAuthServer 启动一个使用 HMAC 认证的 RPC 服务器，返回 XDial 使用的地址。
+func startAuthServer(t *testing.T, secret []byte) string {
+	t.Helper()
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := "tcp@" + lis.Addr().String()
+	server := NewServer(g, t.Name(), addr)
+	server.SetAuth(&HMACAuthenticator{Secret: secret}, nil)
+	go server.Accept(lis)
+	return addr
+}
+
+func callWith(addr string, opt *Option) error {
+	client, err := XDial(addr, opt)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key, reply := "k", ""
+	return client.Call(ctx, "Group.Get", &key, &reply)
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	secret := []byte("secret")
+	addr := startAuthServer(t, secret)
+
+	opt := &Option{ConnectTimeout: 5 * time.Second}
+	SignOption(opt, "node", secret)
+	if err := callWith(addr, opt); err != nil {
+		t.Fatalf("signed call: %v", err)
+	}
+
+	// 同一个签名再次握手属于重放，客户端应当收到认证错误而不是 EOF
+	replayed := *opt
+	err := callWith(addr, &replayed)
+	if err == nil || !strings.Contains(err.Error(), ErrUnauthenticated.Error()) {
+		t.Fatalf("replayed handshake: got %v, want %v", err, ErrUnauthenticated)
+	}
+
+	wrong := &Option{ConnectTimeout: 5 * time.Second}
+	SignOption(wrong, "node", []byte("other"))
+	if err := callWith(addr, wrong); err == nil || !strings.Contains(err.Error(), "bad signature") {
+		t.Fatalf("wrong secret: got %v, want bad signature", err)
+	}
+}
+
+func TestHMACAuthenticatorReplay(t *testing.T) {
+	a := &HMACAuthenticator{Secret: []byte("secret")}
+	opt := &Option{}
+	SignOption(opt, "node", a.Secret)
+	if _, err := a.Authenticate(opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(opt); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("replay: got %v, want %v", err, ErrUnauthenticated)
+	}
+	// 篡改 Nonce 后签名不再匹配
+	SignOption(opt, "node", a.Secret)
+	opt.Nonce = "00"
+	if _, err := a.Authenticate(opt); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("tampered nonce: got %v, want %v", err, ErrUnauthenticated)
+	}
+}
+
+// TestPeerPolicy 检查只有节点和 API 服务器可以调用 Group.Put，其他方法不受影响。
+func TestPeerPolicy(t *testing.T) {
+	secret := []byte("secret")
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := "tcp@" + lis.Addr().String()
+	server := NewServer(g, t.Name(), addr)
+	server.SetAuth(&HMACAuthenticator{Secret: secret}, &PeerPolicy{Peers: []string{"node", "api"}})
+	go server.Accept(lis)
+
+	for _, tc := range []struct {
+		identity string
+		allowed  bool
+	}{
+		{"node", true},
+		{"api", true},
+		{"cli", false},
+	} {
+		opt := &Option{ConnectTimeout: 5 * time.Second}
+		SignOption(opt, tc.identity, secret)
+		client, err := XDial(addr, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		v := VersionedValue{Key: "k", Value: tc.identity, Version: newVersion(), Found: true}
+		var reply string
+		err = client.Call(ctx, "Group.Put", &v, &reply)
+		if got := err == nil; got != tc.allowed {
+			t.Errorf("%s: Group.Put error = %v, allowed = %v", tc.identity, err, tc.allowed)
+		}
+		if !tc.allowed && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: Group.Put error = %v, want ErrPermissionDenied", tc.identity, err)
+		}
+		key := "k"
+		if err := client.Call(ctx, "Group.Get", &key, &reply); err != nil {
+			t.Errorf("%s: Group.Get error = %v", tc.identity, err)
+		}
+		cancel()
+		_ = client.Close()
+	}
+}
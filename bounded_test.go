@@ -0,0 +1,129 @@
+package distributecache
+
+import (
+	consistenthash "DistributeCache/consistentHash"
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// countingReporter 记录每个节点收到的请求数。
+type countingReporter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (r *countingReporter) Inc(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = make(map[string]int)
+	}
+	r.calls[node]++
+}
+
+func (r *countingReporter) Done(node string) {}
+
+func (r *countingReporter) take(node string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.calls[node]
+	delete(r.calls, node)
+	return n
+}
+
+func (r *countingReporter) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.calls)
+}
+
+// startBoundedNodes 启动 n 个访问同一个 Group 的节点，节点把收到的请求数记录到 reporter 中。
+func startBoundedNodes(t *testing.T, n int, reporter LoadReporter) []string {
+	t.Helper()
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded " + key), nil
+	}))
+	addrs := make([]string, n)
+	for i := range addrs {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = lis.Close() })
+		addrs[i] = "tcp@" + lis.Addr().String()
+		server := NewServer(g, t.Name(), addrs[i])
+		server.SetLoadReporter(reporter)
+		go server.Accept(lis)
+	}
+	return addrs
+}
+
+// TestBoundedLoadWritesGoToOwner 检查有界负载只影响读请求，写和条件写总是发往主节点。
+func TestBoundedLoadWritesGoToOwner(t *testing.T) {
+	reporter := &countingReporter{}
+	addrs := startBoundedNodes(t, 2, reporter)
+	ring := consistenthash.New(10, nil)
+	ring.Add(addrs...)
+	ring.SetLoadFactor(0.25)
+	clients := NewClientPool(nil)
+	t.Cleanup(func() { _ = clients.Close() })
+	api := NewAPIServer(NewPlacementPicker(ring), clients)
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := ring.GetN(key, 1)[0]
+		// 主节点的负载已满，读请求溢出到另一个节点
+		ring.UpdateLoad(owner, 100)
+		if _, err := api.read(ctx, t.Name(), key, 0); err != nil {
+			t.Fatal(err)
+		}
+		if reporter.take(owner) != 0 {
+			t.Fatalf("%s: read reached the overloaded owner %s", key, owner)
+		}
+		reporter.reset()
+
+		if _, err := api.write(ctx, t.Name(), key, "v", 0); err != nil {
+			t.Fatal(err)
+		}
+		v, err := api.writeIf(ctx, t.Name(), key+"-new", "v", &precondition{absent: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.removeIf(ctx, t.Name(), key+"-new", &precondition{version: v}); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.remove(ctx, t.Name(), key, 0); err != nil {
+			t.Fatal(err)
+		}
+		ownerNew := ring.GetN(key+"-new", 1)[0]
+		if got, want := reporter.take(owner)+reporter.take(ownerNew), 4; got != want {
+			t.Fatalf("%s: owners %s and %s received %d writes, want %d", key, owner, ownerNew, got, want)
+		}
+		ring.UpdateLoad(owner, 0)
+	}
+}
+
+// TestServerReportsLoad 检查节点随响应上报的负载被用来校准 API 服务器哈希环上的负载。
+func TestServerReportsLoad(t *testing.T) {
+	addrs := startBoundedNodes(t, 1, &countingReporter{})
+	ring := consistenthash.New(10, nil)
+	ring.Add(addrs...)
+	ring.SetLoadFactor(0.25)
+	clients := NewClientPool(nil)
+	t.Cleanup(func() { _ = clients.Close() })
+	api := NewAPIServer(NewPlacementPicker(ring), clients)
+	api.SetLoadReporter(ring)
+
+	// 其他调用方发往该节点的请求不经过 ring.Inc，只能通过节点上报的负载计入
+	ring.UpdateLoad(addrs[0], 50)
+	if _, err := api.read(context.Background(), t.Name(), "k", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := ring.Load(addrs[0]); got != 0 {
+		t.Fatalf("load after an idle node answered = %d, want 0", got)
+	}
+}
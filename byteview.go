@@ -4,9 +4,22 @@ package distributecache
 /// ByteView 只有一个数据成员，b []byte，b 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
 /// 实现 Len() int 方法，我们在 lru.Cache 的实现中，要求被缓存对象必须实现 Value 接口，即 Len() int 方法，返回其所占的内存大小。
 /// b 是只读的，使用 ByteSlice() 方法返回一个拷贝，防止缓存值被外部程序修改。
+/// e 是缓存值的过期时间，零值表示永不过期，过期的缓存值在下一次访问时从缓存中移除。
+/// f 是客户端随缓存值一起保存的标志位（例如 memcached 协议中的 flags），缓存本身不解析它。
+/// cas 是缓存值写入本地缓存时分配的唯一编号，每次写入都会改变，用于实现 memcached 的 cas 命令。
+/// v 是写入缓存值时由混合逻辑时钟（参见 HLC）分配的版本号，随缓存值一起复制和迁移到其他节点，版本号大的值更新，从数据源加载的值版本号为 0。
+/// d 表示缓存值是删除标记（墓碑）：删除 key 时写入一个分配了新版本号的删除标记而不是直接移除，
+/// 使版本号更旧的复制写入不能让被删除的值复活。删除标记对读取不可见，在 tombstoneTTL 之后过期。
+
+import "time"
 
 type ByteView struct {
-	b []byte
+	b   []byte
+	e   time.Time
+	f   uint32
+	cas uint64
+	v   uint64
+	d   bool
 }
 
 func NewByteView(b []byte) ByteView {
@@ -24,6 +37,25 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// Expire 返回缓存值的过期时间，零值表示永不过期。
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// Version 返回缓存值的版本号。
+func (v ByteView) Version() uint64 {
+	return v.v
+}
+
+// Flags 返回客户端随缓存值一起保存的标志位。
+func (v ByteView) Flags() uint32 {
+	return v.f
+}
+
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
@@ -6,42 +6,120 @@ package distributecache
 
 import (
 	"DistributeCache/lru"
-	"errors"
+	"strings"
 	"sync"
+	"time"
 )
 
 type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
-	cacheBytes int64 // 缓存容量
+	cacheBytes int64  // 缓存容量
+	cas        uint64 // 最近一次分配的 cas 编号
 }
 
-func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *cache) addLocked(key string, value ByteView) ByteView {
 	if c.lru == nil {
 		c.lru = lru.New(c.cacheBytes, nil)
 	}
+	c.cas++
+	value.cas = c.cas
 	c.lru.Add(key, value)
+	return value
+}
+
+// update 在持有锁的情况下把 key 当前的值交给 fn，fn 返回 true 时写入它返回的新值，
+// 用于实现 add、replace、cas 等需要先检查再写入的操作。ok 为 false 表示 key 不存在、已经过期或者已经删除，
+// 已经删除时 old 是删除标记，old.v 是删除时的版本号。写入时返回分配了 cas 编号的新值。
+func (c *cache) update(key string, fn func(old ByteView, ok bool) (ByteView, bool)) (ByteView, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old ByteView
+	ok := false
+	if c.lru != nil {
+		if v, found := c.lru.Get(key); found && !v.(ByteView).expired(time.Now()) {
+			old = v.(ByteView)
+			ok = !old.d
+		}
+	}
+	value, store := fn(old, ok)
+	if !store {
+		return ByteView{}, false
+	}
+	return c.addLocked(key, value), true
 }
 
+// get 返回 key 的缓存值，删除标记视为不存在。
 func (c *cache) get(key string) (value ByteView, ok bool) {
+	if value, ok = c.getVersioned(key); value.d {
+		return ByteView{}, false
+	}
+	return value, ok
+}
+
+// getVersioned 与 get 相同，但同时返回删除标记。
+func (c *cache) getVersioned(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
 		return
 	}
 	if v, ok := c.lru.Get(key); ok {
+		// 过期的缓存值视为不存在，并从缓存中移除
+		if v.(ByteView).expired(time.Now()) {
+			_ = c.lru.Delete(key)
+			return ByteView{}, false
+		}
 		return v.(ByteView), ok
 	}
 	return
 }
 
-func (c *cache) delete(key string) error {
+// stats 返回缓存的条目数和已经使用的内存
+func (c *cache) stats() (keys int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0, 0
+	}
+	return c.lru.Len(), c.lru.Bytes()
+}
+
+// keys 返回键以 prefix 开头的所有 key。只拷贝 key，持有锁的时间和占用的内存都与值的大小无关。
+func (c *cache) keys(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	var keys []string
+	c.lru.Range(func(key string, value lru.Value) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// entries 返回 keys 中仍然存在且没有过期的缓存条目，跳过删除标记，不会改变它们的淘汰顺序。
+// ByteView 是只读的，因此不需要拷贝数据。
+func (c *cache) entries(keys []string) []Entry {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		return errors.New("cache is nil")
+		return nil
+	}
+	entries := make([]Entry, 0, len(keys))
+	now := time.Now()
+	for _, key := range keys {
+		value, ok := c.lru.Peek(key)
+		if !ok {
+			continue
+		}
+		if v := value.(ByteView); !v.d && !v.expired(now) {
+			entries = append(entries, Entry{Key: key, Value: v.b, Expire: v.e, Flags: v.f, Version: v.v})
+		}
 	}
-	return c.lru.Delete(key)
+	return entries
 }
@@ -0,0 +1,103 @@
+package distributecache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConflict 表示条件写入的前置条件不满足，即 key 当前的版本号与期望的不同。
+var ErrConflict = errors.New("version conflict")
+
+// ConflictError 是条件写入失败时返回的错误，Version 是 key 当前的版本号。
+type ConflictError struct {
+	Key     string
+	Version uint64
+}
+
+func (e *ConflictError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%v, current version %d", ErrConflict, e.Version)
+	}
+	return fmt.Sprintf("%s: %v, current version %d", e.Key, ErrConflict, e.Version)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// ConflictVersion 判断 err 是否为版本冲突，是则返回 key 当前的版本号。
+// 经过 RPC 的冲突由 Header.Version 带回版本号，客户端的 RemoteError 可以转换为 *ConflictError。
+func ConflictVersion(err error) (uint64, bool) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return conflict.Version, true
+	}
+	return 0, false
+}
+
+// CompareAndSwap 只有 key 当前的版本号等于 expected 时才写入 value，返回分配了新版本号的值。
+// key 不存在时返回 ErrNotFound，版本号不同时返回 *ConflictError。
+// 与 Insert 一样只检查本地缓存，不会从数据源加载 key。
+func (g *Group) CompareAndSwap(key string, expected uint64, value ByteView) (ByteView, error) {
+	var err error
+	stored, ok := g.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		switch {
+		case !ok:
+			err = fmt.Errorf("%s: %w", key, ErrNotFound)
+		case old.v != expected:
+			err = &ConflictError{Key: key, Version: old.v}
+		default:
+			value.v = newVersion()
+		}
+		return value, err == nil
+	})
+	if !ok {
+		return ByteView{}, err
+	}
+	return stored, nil
+}
+
+// InsertIfAbsent 只有 key 不存在时才写入 value，返回分配了版本号的值。key 已经存在时返回 *ConflictError。
+func (g *Group) InsertIfAbsent(key string, value ByteView) (ByteView, error) {
+	var err error
+	stored, ok := g.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		if ok {
+			err = &ConflictError{Key: key, Version: old.v}
+			return old, false
+		}
+		value.v = newVersion()
+		return value, true
+	})
+	if !ok {
+		return ByteView{}, err
+	}
+	return stored, nil
+}
+
+// DeleteIfVersion 只有 key 当前的版本号等于 expected 时才删除 key。
+// key 不存在时返回 ErrNotFound，版本号不同时返回 *ConflictError。
+func (g *Group) DeleteIfVersion(key string, expected uint64) error {
+	_, err := g.deleteIfVersion(key, expected)
+	return err
+}
+
+// deleteIfVersion 是 DeleteIfVersion 的实现，返回写入的删除标记。
+func (g *Group) deleteIfVersion(key string, expected uint64) (ByteView, error) {
+	var err error
+	dead, ok := g.mainCache.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		switch {
+		case !ok:
+			err = fmt.Errorf("%s: %w", key, ErrNotFound)
+		case old.v != expected:
+			err = &ConflictError{Key: key, Version: old.v}
+		default:
+			return tombstone(), true
+		}
+		return ByteView{}, false
+	})
+	if !ok {
+		return ByteView{}, err
+	}
+	g.notify(EventDelete, key, ByteView{})
+	return dead, nil
+}
@@ -0,0 +1,149 @@
+package distributecache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func newCASGroup(t *testing.T) *Group {
+	return NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded " + key), nil
+	}))
+}
+
+// expectConflict 检查 err 是版本冲突，并且带有 key 当前的版本号 version。
+func expectConflict(t *testing.T, op string, err error, version uint64) {
+	t.Helper()
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("%s = %v, want ErrConflict", op, err)
+	}
+	if v, ok := ConflictVersion(err); !ok || v != version {
+		t.Fatalf("%s: conflict version = %d, %v, want %d", op, v, ok, version)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	g := newCASGroup(t)
+	old := g.insert("k", ByteView{b: []byte("v1")})
+
+	stored, err := g.CompareAndSwap("k", old.v, ByteView{b: []byte("v2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.v <= old.v || stored.String() != "v2" {
+		t.Fatalf("CompareAndSwap stored %+v, want v2 with a version newer than %d", stored, old.v)
+	}
+
+	_, err = g.CompareAndSwap("k", old.v, ByteView{b: []byte("v3")})
+	expectConflict(t, "CompareAndSwap with a stale version", err, stored.v)
+	if v, _ := g.lookup("k"); v.String() != "v2" {
+		t.Fatalf("a conflicting CompareAndSwap changed the value to %q", v)
+	}
+
+	// 不存在的 key 不会从数据源加载
+	if _, err := g.CompareAndSwap("missing", 0, ByteView{b: []byte("v")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("CompareAndSwap on a missing key = %v, want ErrNotFound", err)
+	}
+	dead, err := g.delete("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.CompareAndSwap("k", dead.v, ByteView{b: []byte("v")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("CompareAndSwap on a tombstone = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+	g := newCASGroup(t)
+	stored, err := g.InsertIfAbsent("k", ByteView{b: []byte("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.InsertIfAbsent("k", ByteView{b: []byte("v2")})
+	expectConflict(t, "InsertIfAbsent on an existing key", err, stored.v)
+
+	// 删除标记视为 key 不存在，新写入的版本号比删除标记新
+	dead, err := g.delete("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := g.InsertIfAbsent("k", ByteView{b: []byte("v3")})
+	if err != nil {
+		t.Fatalf("InsertIfAbsent on a tombstone = %v", err)
+	}
+	if again.v <= dead.v {
+		t.Fatalf("version %d is not newer than the tombstone %d", again.v, dead.v)
+	}
+}
+
+func TestDeleteIfVersion(t *testing.T) {
+	g := newCASGroup(t)
+	stored := g.insert("k", ByteView{b: []byte("v")})
+
+	expectConflict(t, "DeleteIfVersion with a wrong version", g.DeleteIfVersion("k", stored.v+1), stored.v)
+	if _, ok := g.lookup("k"); !ok {
+		t.Fatal("a conflicting DeleteIfVersion deleted the key")
+	}
+
+	if err := g.DeleteIfVersion("k", stored.v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookup("k"); ok {
+		t.Fatal("key is still visible after DeleteIfVersion")
+	}
+	if dead, ok := g.lookupVersioned("k"); !ok || !dead.d || dead.v <= stored.v {
+		t.Fatalf("tombstone = %+v, want one newer than %d", dead, stored.v)
+	}
+	if err := g.DeleteIfVersion("k", stored.v); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteIfVersion on a tombstone = %v, want ErrNotFound", err)
+	}
+	if err := g.DeleteIfVersion("missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteIfVersion on a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+// TestConditionalRPC 检查条件写入经过 RPC 调用时，冲突以错误返回并带有 key 当前的版本号，
+// 成功的写入和删除标记被转发到副本，冲突的写入不会被转发。
+func TestConditionalRPC(t *testing.T) {
+	g := newCASGroup(t)
+	replica, recorder := startReplica(t, g)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	server := NewServer(g, "primary", "tcp@"+lis.Addr().String())
+	server.SetReplicator(newTestReplicator(t, g, replica))
+	go server.Accept(lis)
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	var stored VersionedValue
+	if err := client.Call(ctx, "Group.InsertIfAbsent", &VersionedValue{Key: "k", Value: "v1"}, &stored); err != nil {
+		t.Fatal(err)
+	}
+	expectReplicated(t, recorder, "InsertIfAbsent", "Group.Put")
+
+	var reply VersionedValue
+	err = client.Call(ctx, "Group.InsertIfAbsent", &VersionedValue{Key: "k", Value: "v2"}, &reply)
+	expectConflict(t, "Group.InsertIfAbsent", err, stored.Version)
+	err = client.Call(ctx, "Group.CompareAndSwap", &VersionedValue{Key: "k", Value: "v2", Version: stored.Version + 1}, &reply)
+	expectConflict(t, "Group.CompareAndSwap", err, stored.Version)
+	err = client.Call(ctx, "Group.DeleteIfVersion", &VersionedValue{Key: "k", Version: stored.Version + 1}, &reply)
+	expectConflict(t, "Group.DeleteIfVersion", err, stored.Version)
+	expectReplicated(t, recorder, "conflicts")
+
+	if err := client.Call(ctx, "Group.DeleteIfVersion", &VersionedValue{Key: "k", Version: stored.Version}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Deleted || reply.Version <= stored.Version {
+		t.Fatalf("DeleteIfVersion replied %+v, want a tombstone newer than %d", reply, stored.Version)
+	}
+	expectReplicated(t, recorder, "DeleteIfVersion", "Group.Put")
+}
@@ -1,11 +1,23 @@
 package codec
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Header struct {
 	ServiceMethod string // 服务名和方法名，通常与 Go 语言中的结构体和方法相映射
 	Seq           uint64 // 请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求。
 	Error         string // 错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中。
+	Code          string // 错误的类型，与 Error 一起设置，客户端据此识别错误，例如 not_found。
+	Group         string // 请求访问的 group 名称，为空时访问服务端默认的 group。
+	Stream        bool   // 是否为流式响应帧，流式帧的 Body 是一段 []byte 数据。
+	More          bool   // 流式响应后续是否还有帧，最后一帧为 false。
+	Ping          bool   // keepalive 探测帧，对端收到后回复 Pong，Body 是发送时的时间戳。
+	Pong          bool   // keepalive 探测帧的回复。
+	Ack           bool   // 流式响应的确认帧，客户端处理完若干帧后发送，Seq 是对应的调用，Body 是处理完的帧数，-1 表示放弃读取。
+	Load          int64  // 服务端发送响应时正在处理的请求数（包括本次请求），用于有界负载的一致性哈希。
+	Version       uint64 // Code 为 conflict 时 key 当前的版本号。
 }
 
 // 抽象出对消息体进行编解码的接口 Codec，抽象出接口是为了实现不同的 Codec
@@ -16,6 +28,13 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BatchWriter 由支持批量写的 Codec 实现。
+// 开启后 Write 不再每次都刷新缓冲区，而是在 linger 时间窗口内合并多次 Write，只刷新一次，
+// 从而把并发请求的多次系统调用合并为一次。
+type BatchWriter interface {
+	EnableBatching(linger time.Duration)
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
@@ -5,6 +5,8 @@ import (
 	"encoding/gob"
 	"io"
 	"log"
+	"sync"
+	"time"
 )
 
 type GobCodec struct {
@@ -12,9 +14,16 @@ type GobCodec struct {
 	buf  *bufio.Writer
 	dec  *gob.Decoder
 	enc  *gob.Encoder
+
+	mu       sync.Mutex    // 开启批量写后，保护 buf 不被 Write 和定时刷新并发访问
+	linger   time.Duration // 大于 0 时开启批量写
+	flushing bool          // 是否已经安排了一次刷新
 }
 
-var _ Codec = (*GobCodec)(nil)
+var (
+	_ Codec       = (*GobCodec)(nil)
+	_ BatchWriter = (*GobCodec)(nil)
+)
 
 // 消息的编解码器 GobCodec
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
@@ -37,9 +46,22 @@ func (c *GobCodec) ReadBody(body interface{}) error {
 	return c.dec.Decode(body)
 }
 
+// EnableBatching 开启批量写，需要在第一次 Write 之前调用。
+func (c *GobCodec) EnableBatching(linger time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.linger = linger
+}
+
 func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	defer func() {
-		c.buf.Flush()
+		if err == nil && c.linger > 0 {
+			c.scheduleFlush()
+		} else {
+			c.buf.Flush()
+		}
 		if err != nil {
 			_ = c.conn.Close()
 		}
@@ -55,6 +77,31 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 	return nil
 }
 
+// scheduleFlush 在 linger 之后刷新缓冲区，期间的 Write 只写入缓冲区，由同一次刷新发送。
+func (c *GobCodec) scheduleFlush() {
+	if c.flushing {
+		return
+	}
+	c.flushing = true
+	time.AfterFunc(c.linger, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.flushing = false
+		if err := c.buf.Flush(); err != nil {
+			log.Println("rpc codec: gob error flushing:", err)
+			_ = c.conn.Close()
+		}
+	})
+}
+
 func (c *GobCodec) Close() error {
+	// 关闭前尽量发送批量写缓冲区中尚未刷新的数据。
+	// 正在写入时不等待锁，直接关闭连接，以便解除阻塞在对端不可达的连接上的写操作。
+	if c.mu.TryLock() {
+		if c.flushing {
+			c.buf.Flush()
+		}
+		c.mu.Unlock()
+	}
 	return c.conn.Close()
 }
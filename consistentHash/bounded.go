@@ -0,0 +1,88 @@
+package consistenthash
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// 有界负载的一致性哈希（Consistent Hashing with Bounded Loads）：
+// 每个节点的负载上限是 ceil((1+ε) * (总负载+1) / 节点数)，Get 从 key 在环上的位置开始顺时针查找，
+// 跳过负载已经达到上限的节点，使热点 key 所在区间的请求溢出到相邻的节点。
+// 负载由调用方通过 Inc、Done 或者 UpdateLoad 上报，例如转发请求前调用 Inc，请求完成后调用 Done。
+
+// SetLoadFactor 设置负载系数 ε 并开启有界负载模式，ε 小于等于 0 时关闭。
+// ε 越小负载越均衡，但 key 越容易被分配到非首选的节点上，常用的取值是 0.25。
+func (m *Map) SetLoadFactor(factor float64) {
+	m.factor.Store(math.Float64bits(max(factor, 0)))
+}
+
+// LoadFactor 返回负载系数 ε，为 0 表示没有开启有界负载模式。
+func (m *Map) LoadFactor() float64 {
+	return math.Float64frombits(m.factor.Load())
+}
+
+// counter 返回节点的负载计数器，节点不在环上时返回 nil。
+func (m *Map) counter(node string) *atomic.Int64 {
+	if _, ok := m.ring.Load().nodes[node]; !ok {
+		return nil
+	}
+	c, _ := m.loads.LoadOrStore(node, new(atomic.Int64))
+	return c.(*atomic.Int64)
+}
+
+// Inc 使节点的负载加一。
+func (m *Map) Inc(node string) {
+	if c := m.counter(node); c != nil {
+		c.Add(1)
+		m.total.Add(1)
+	}
+}
+
+// Done 使节点的负载减一，与 Inc 成对调用。
+func (m *Map) Done(node string) {
+	if c, ok := m.loads.Load(node); ok {
+		c.(*atomic.Int64).Add(-1)
+		m.total.Add(-1)
+	}
+}
+
+// UpdateLoad 把节点的负载设置为 load，用于节点定期上报自身负载的场景。
+func (m *Map) UpdateLoad(node string, load int64) {
+	if c := m.counter(node); c != nil {
+		m.total.Add(load - c.Swap(load))
+	}
+}
+
+// Load 返回节点当前的负载。
+func (m *Map) Load(node string) int64 {
+	if c, ok := m.loads.Load(node); ok {
+		return c.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// forget 删除节点的负载，节点从环上删除时调用。
+func (m *Map) forget(node string) {
+	if c, ok := m.loads.LoadAndDelete(node); ok {
+		m.total.Add(-c.(*atomic.Int64).Swap(0))
+	}
+}
+
+// maxLoad 返回 n 个节点时每个节点的负载上限。
+func (m *Map) maxLoad(n int, factor float64) int64 {
+	avg := float64(m.total.Load()+1) / float64(n)
+	return int64(math.Ceil(avg * (1 + factor)))
+}
+
+// bounded 从环上的下标 idx 开始顺时针查找第一个负载没有达到上限的节点，
+// 所有节点的负载都达到上限时（只会在负载并发变化时出现）返回首选节点。
+func (m *Map) bounded(r *ring, idx int, factor float64) string {
+	limit := m.maxLoad(len(r.nodes), factor)
+	for i := 0; i < len(r.keys); i++ {
+		node := r.hashMap[r.keys[(idx+i)%len(r.keys)]]
+		if m.Load(node)+1 <= limit {
+			return node
+		}
+	}
+	return r.hashMap[r.keys[idx%len(r.keys)]]
+}
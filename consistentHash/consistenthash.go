@@ -2,8 +2,11 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"slices"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 // 定义了函数类型 Hash，
@@ -15,11 +18,25 @@ import (
 // 虚拟节点与真实节点的映射表 hashMap，键是虚拟节点的哈希值，值是真实节点的名称。
 type Hash func(data []byte) uint32
 
+// Map 可以被并发使用：哈希环保存在不可变的快照 ring 中，Get 原子地读取当前快照，不需要加锁；
+// Add、Remove 和 Update 持有 mu 串行执行，复制当前快照修改后再原子地替换。
 type Map struct {
 	hash     Hash
 	replicas int
-	keys     []int
-	hashMap  map[int]string
+	mu       sync.Mutex // 串行化写操作
+	ring     atomic.Pointer[ring]
+
+	// 有界负载模式使用的负载信息，见 bounded.go
+	factor atomic.Uint64 // 负载系数 ε 的 math.Float64bits，为 0 时不限制负载
+	loads  sync.Map      // 真实节点的负载，值是 *atomic.Int64
+	total  atomic.Int64  // 所有节点的负载之和
+}
+
+// ring 是哈希环的快照，创建后不再修改
+type ring struct {
+	keys    []int
+	hashMap map[int]string
+	nodes   map[string]int // 真实节点的虚拟节点数量
 }
 
 // 构造函数 New() 允许自定义虚拟节点倍数和 Hash 函数
@@ -27,59 +44,157 @@ func New(replicas int, fn Hash) *Map {
 	m := &Map{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
 	}
 
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
 	}
+	m.ring.Store(&ring{hashMap: make(map[int]string), nodes: make(map[string]int)})
 
 	return m
 }
 
-// Add 方法用于向 Map 中添加键。
+// Add 方法用于向 Map 中添加权重为 1 的真实节点，已经存在的节点保持原来的权重。
 // 对每一个真实节点 key，对应创建 m.replicas 个虚拟节点，虚拟节点的名称是：strconv.Itoa(i) + key，即通过添加编号的方式区分不同虚拟节点。
-// 使用 m.hash() 计算虚拟节点的哈希值，使用 append(m.keys, hash) 添加到环上。
-// 在 hashMap 中增加虚拟节点和真实节点的映射关系。
+// 使用 m.hash() 计算虚拟节点的哈希值，添加到环上，并在 hashMap 中增加虚拟节点和真实节点的映射关系。
 // 最后一步，环上的哈希值排序。
-func (m *Map) Add(key string) {
-	for i := 0; i < m.replicas; i++ {
-		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-		m.keys = append(m.keys, hash)
-		m.hashMap[hash] = key
+func (m *Map) Add(keys ...string) {
+	m.Update(keys, nil)
+}
+
+// AddWeighted 添加节点 key 或者修改它的权重，节点的虚拟节点数量是 m.replicas * weight，weight 小于等于 0 时删除节点。
+// 修改权重时只增加或删除编号超出范围的虚拟节点，其余虚拟节点保持不变，只有这部分虚拟节点上的 key 会迁移。
+func (m *Map) AddWeighted(key string, weight int) {
+	m.SetWeights(map[string]int{key: weight})
+}
+
+// Remove 方法用于从一致性哈希 Map 中删除真实节点及其所有虚拟节点。
+func (m *Map) Remove(keys ...string) {
+	m.Update(nil, keys)
+}
+
+// Update 在一次快照替换中删除 removed 中的节点并添加 added 中的节点，新节点的权重为 1，已经存在的节点保持原来的权重。
+// 并发的 Get 要么看到修改前的环，要么看到全部修改完成后的环，不会看到只修改了一部分的环。
+func (m *Map) Update(added, removed []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nodes := m.ring.Load().nodes
+	m.setWeights(updateWeights(added, removed, func(key string) int {
+		return nodes[key] / max(m.replicas, 1)
+	}))
+}
+
+// SetWeights 在一次快照替换中把每个节点的权重设置为 weights 中的值，权重小于等于 0 表示删除节点。
+// 所有节点的权重都没有变化时不替换快照。
+func (m *Map) SetWeights(weights map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setWeights(weights)
+}
+
+// setWeights 是 SetWeights 的实现，调用方需要持有 mu。
+func (m *Map) setWeights(weights map[string]int) {
+	old := m.ring.Load()
+	changed := false
+	for key, weight := range weights {
+		if old.nodes[key] != m.replicas*max(weight, 0) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+	next := &ring{
+		hashMap: make(map[int]string, len(old.hashMap)),
+		nodes:   make(map[string]int, len(old.nodes)+len(weights)),
 	}
-	sort.Ints(m.keys)
+	for hash, key := range old.hashMap {
+		next.hashMap[hash] = key
+	}
+	for key, n := range old.nodes {
+		next.nodes[key] = n
+	}
+
+	// 按名称的顺序处理，哈希冲突时名称较小的节点得到虚拟节点，使不同进程中的哈希环与 map 的遍历顺序和节点加入的顺序无关
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		have, want := next.nodes[key], m.replicas*max(weights[key], 0)
+		// 删除编号在 [want, have) 的虚拟节点，哈希冲突时虚拟节点可能属于其他节点，不能删除
+		for i := want; i < have; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+			if next.hashMap[hash] == key {
+				delete(next.hashMap, hash)
+			}
+		}
+		// 添加编号在 [have, want) 的虚拟节点
+		for i := have; i < want; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+			if owner, ok := next.hashMap[hash]; !ok || key < owner {
+				next.hashMap[hash] = key
+			}
+		}
+		if want == 0 {
+			delete(next.nodes, key)
+			m.forget(key)
+		} else {
+			next.nodes[key] = want
+		}
+	}
+
+	next.keys = make([]int, 0, len(next.hashMap))
+	for hash := range next.hashMap {
+		next.keys = append(next.keys, hash)
+	}
+	sort.Ints(next.keys)
+	m.ring.Store(next)
 }
 
 // 实现选择节点的 Get() 方法。
 // 第一步，计算 key 的哈希值。
-// 第二步，顺时针找到第一个匹配的虚拟节点的下标 idx，从 m.keys 中获取到对应的哈希值。如果 idx == len(m.keys)，说明应选择 m.keys[0]，因为 m.keys 是一个环状结构，所以用取余数的方式来处理这种情况。
-// 第三步，通过 hashMap 映射得到真实的节点。
+// 第二步，顺时针找到第一个匹配的虚拟节点的下标 idx，从 keys 中获取到对应的哈希值。如果 idx == len(keys)，说明应选择 keys[0]，因为 keys 是一个环状结构，所以用取余数的方式来处理这种情况。
+// 第三步，通过 hashMap 映射得到真实的节点。开启有界负载时跳过负载已满的节点。
 func (m *Map) Get(key string) string {
-	if len(m.keys) == 0 {
+	r := m.ring.Load()
+	if len(r.keys) == 0 {
 		return ""
 	}
 
 	hash := int(m.hash([]byte(key)))
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
+	idx := sort.Search(len(r.keys), func(i int) bool {
+		return r.keys[i] >= hash
 	})
 
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	if factor := m.LoadFactor(); factor > 0 {
+		return m.bounded(r, idx, factor)
+	}
+	return r.hashMap[r.keys[idx%len(r.keys)]]
 }
 
-// Remove 方法用于从一致性哈希 Map 中删除一个真实节点及其所有虚拟节点。
-// 首先遍历 m.replicas 次，每次计算虚拟节点的哈希值。
-// 使用 sort.SearchInts 在 m.keys 中查找哈希值的位置。
-// 如果找到了对应的哈希值，则从 m.keys 切片中移除，并且从 hashMap 中删除相应的键值对。
-// 这个过程重复 m.replicas 次，以确保所有虚拟节点都被删除。
-func (m *Map) Remove(key string) {
-	for i := 0; i < m.replicas; i++ {
-		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-		idx := sort.SearchInts(m.keys, hash)
-		if idx < len(m.keys) && m.keys[idx] == hash {
-			delete(m.hashMap, hash)
-			m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+// GetN 返回 key 的 n 个不同的真实节点：从 key 在环上的位置开始顺时针查找，跳过已经选中的真实节点。
+// 第一个节点与没有开启有界负载时 Get 的结果相同，节点数少于 n 时返回所有节点。
+func (m *Map) GetN(key string, n int) []string {
+	r := m.ring.Load()
+	if len(r.keys) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(r.nodes))
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(r.keys), func(i int) bool {
+		return r.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(r.keys) && len(nodes) < n; i++ {
+		node := r.hashMap[r.keys[(idx+i)%len(r.keys)]]
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
 		}
 	}
+	return nodes
 }
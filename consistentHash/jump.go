@@ -0,0 +1,87 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+	"sync/atomic"
+)
+
+// Jump 是跳跃一致性哈希（Lamping & Veach）：不需要额外的内存，计算量是 O(ln n)，分布非常均匀。
+// 桶按节点名称排序，使同一组成员在所有进程中得到相同的映射，与加入的顺序和历史无关。
+// 算法只能在末尾增加或删除桶，名称排在最后的节点加入或离开时只有它上面的 key 迁移；
+// 其他位置的节点变化会使后面的桶整体移动，迁移的 key 远多于哈希环，适合成员很少变化的场景。
+// 权重为 w 的节点占用 w 个连续的桶。
+type Jump struct {
+	hash    Hash
+	nodes   nodeSet
+	buckets atomic.Pointer[slotTable]
+}
+
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	j := &Jump{hash: fn}
+	j.buckets.Store(&slotTable{})
+	return j
+}
+
+func (j *Jump) Add(nodes ...string)    { j.Update(nodes, nil) }
+func (j *Jump) Remove(nodes ...string) { j.Update(nil, nodes) }
+
+func (j *Jump) Update(added, removed []string) {
+	j.nodes.mu.Lock()
+	defer j.nodes.mu.Unlock()
+	j.setWeights(updateWeights(added, removed, j.nodes.weight))
+}
+
+func (j *Jump) SetWeights(weights map[string]int) {
+	j.nodes.mu.Lock()
+	defer j.nodes.mu.Unlock()
+	j.setWeights(weights)
+}
+
+// setWeights 是 SetWeights 的实现，调用方需要持有 j.nodes.mu。
+func (j *Jump) setWeights(weights map[string]int) {
+	order, changed := j.nodes.apply(weights)
+	if !changed {
+		return
+	}
+	// 与 Maglev 相同，桶的顺序只与节点集合有关，否则不同的 API 服务器会把同一个 key 映射到不同的节点
+	sort.Strings(order)
+	buckets := &slotTable{nodes: len(order)}
+	for _, name := range order {
+		for i := 0; i < j.nodes.weights[name]; i++ {
+			buckets.slots = append(buckets.slots, name)
+		}
+	}
+	j.buckets.Store(buckets)
+}
+
+func (j *Jump) Get(key string) string {
+	buckets := j.buckets.Load().slots
+	if len(buckets) == 0 {
+		return ""
+	}
+	return buckets[jumpHash(mix(uint64(j.hash([]byte(key)))), len(buckets))]
+}
+
+// GetN 从 Get 选中的桶开始依次向后查找，返回 n 个不同的节点。
+func (j *Jump) GetN(key string, n int) []string {
+	buckets := j.buckets.Load()
+	if n <= 0 || len(buckets.slots) == 0 {
+		return nil
+	}
+	return buckets.distinct(jumpHash(mix(uint64(j.hash([]byte(key)))), len(buckets.slots)), n)
+}
+
+// jumpHash 把 key 映射到 [0, n) 中的一个桶。
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
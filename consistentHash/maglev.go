@@ -0,0 +1,112 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+	"sync/atomic"
+)
+
+// defaultMaglevSize 是查找表的默认大小，需要是远大于节点数的质数。
+const defaultMaglevSize = 65537
+
+// Maglev 是 Google Maglev 负载均衡器使用的一致性哈希：每个节点根据自己的哈希值生成一个查找表位置的排列，
+// 节点轮流按照排列认领查找表中的空位，Get 只需要一次查表。分布非常均匀，成员变化时迁移的 key 略多于哈希环。
+// 权重为 w 的节点每一轮认领 w 个位置。
+type Maglev struct {
+	hash  Hash
+	size  uint64
+	nodes nodeSet
+	table atomic.Pointer[slotTable]
+}
+
+// NewMaglev 创建大小为 size 的查找表，size 应当是质数，为 0 时使用 defaultMaglevSize。
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	m := &Maglev{hash: fn, size: uint64(size)}
+	m.table.Store(&slotTable{})
+	return m
+}
+
+func (m *Maglev) Add(nodes ...string)    { m.Update(nodes, nil) }
+func (m *Maglev) Remove(nodes ...string) { m.Update(nil, nodes) }
+
+func (m *Maglev) Update(added, removed []string) {
+	m.nodes.mu.Lock()
+	defer m.nodes.mu.Unlock()
+	m.setWeights(updateWeights(added, removed, m.nodes.weight))
+}
+
+func (m *Maglev) SetWeights(weights map[string]int) {
+	m.nodes.mu.Lock()
+	defer m.nodes.mu.Unlock()
+	m.setWeights(weights)
+}
+
+// setWeights 是 SetWeights 的实现，调用方需要持有 m.nodes.mu。
+func (m *Maglev) setWeights(weights map[string]int) {
+	names, changed := m.nodes.apply(weights)
+	if !changed {
+		return
+	}
+	// 查找表只与节点集合有关，与加入的顺序无关
+	sort.Strings(names)
+	m.table.Store(m.populate(names))
+}
+
+// populate 按照 Maglev 论文中的算法填充查找表。
+func (m *Maglev) populate(names []string) *slotTable {
+	if len(names) == 0 {
+		return &slotTable{}
+	}
+	offsets := make([]uint64, len(names))
+	skips := make([]uint64, len(names))
+	next := make([]uint64, len(names))
+	for i, name := range names {
+		h := mix(uint64(m.hash([]byte(name))))
+		offsets[i] = h % m.size
+		skips[i] = mix(h)%(m.size-1) + 1
+	}
+	table := make([]string, m.size)
+	filled := uint64(0)
+	for {
+		for i, name := range names {
+			for turn := 0; turn < m.nodes.weights[name]; turn++ {
+				// 找到该节点排列中下一个空位
+				for {
+					c := (offsets[i] + next[i]*skips[i]) % m.size
+					next[i]++
+					if table[c] == "" {
+						table[c] = name
+						break
+					}
+				}
+				if filled++; filled == m.size {
+					return &slotTable{slots: table, nodes: len(names)}
+				}
+			}
+		}
+	}
+}
+
+func (m *Maglev) Get(key string) string {
+	table := m.table.Load().slots
+	if len(table) == 0 {
+		return ""
+	}
+	return table[mix(uint64(m.hash([]byte(key))))%uint64(len(table))]
+}
+
+// GetN 从 Get 查到的位置开始依次向后查找，返回 n 个不同的节点。
+// 查找表中相邻位置的节点来自不同节点的排列，分布接近随机。
+func (m *Maglev) GetN(key string, n int) []string {
+	table := m.table.Load()
+	if n <= 0 || len(table.slots) == 0 {
+		return nil
+	}
+	return table.distinct(int(mix(uint64(m.hash([]byte(key))))%uint64(len(table.slots))), n)
+}
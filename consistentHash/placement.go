@@ -0,0 +1,161 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Placement 根据 key 选择节点，Map（哈希环）、Rendezvous、Jump 和 Maglev 实现了该接口。
+// 所有实现都可以被并发使用：Get 读取不可变的快照，修改成员的方法串行执行后原子地替换快照。
+type Placement interface {
+	Get(key string) string
+	// GetN 返回 key 的 n 个不同的节点，按优先级排列，第一个与 Get 的结果相同，节点数少于 n 时返回所有节点
+	GetN(key string, n int) []string
+	// Add 添加权重为 1 的节点，已经存在的节点保持原来的权重
+	Add(nodes ...string)
+	Remove(nodes ...string)
+	// Update 在一次快照替换中删除 removed 中的节点并添加 added 中的节点，新节点的权重为 1，已经存在的节点保持原来的权重
+	Update(added, removed []string)
+	// SetWeights 在一次快照替换中设置节点的权重，权重小于等于 0 表示删除节点，权重没有变化时不替换快照
+	SetWeights(weights map[string]int)
+}
+
+// Placements 是 NewPlacement 支持的所有算法。
+var Placements = []string{"ring", "rendezvous", "jump", "maglev"}
+
+// NewPlacement 根据名称创建选择节点的算法：ring（带虚拟节点的哈希环，replicas 是虚拟节点倍数）、
+// rendezvous（最高随机权重哈希）、jump（跳跃一致性哈希）或 maglev，名称为空时使用 ring。
+func NewPlacement(kind string, replicas int, fn Hash) (Placement, error) {
+	switch kind {
+	case "", "ring":
+		return New(replicas, fn), nil
+	case "rendezvous":
+		return NewRendezvous(fn), nil
+	case "jump":
+		return NewJump(fn), nil
+	case "maglev":
+		return NewMaglev(0, fn), nil
+	}
+	return nil, fmt.Errorf("consistenthash: unknown placement %q", kind)
+}
+
+// GetNByZone 返回 key 的 n 个不同的节点，优先选择位于不同可用区的节点：
+// 按照 p.GetN 的顺序先选择每个可用区的第一个节点，不足 n 个时再按顺序补充其余的节点。
+// zone 返回节点所在的可用区，返回空字符串的节点视为各自位于不同的可用区。
+func GetNByZone(p Placement, key string, n int, zone func(node string) string) []string {
+	if n <= 0 {
+		return nil
+	}
+	all := p.GetN(key, math.MaxInt)
+	n = min(n, len(all))
+	nodes := make([]string, 0, n)
+	zones := make(map[string]bool)
+	var rest []string
+	for _, node := range all {
+		z := zone(node)
+		if len(nodes) < n && (z == "" || !zones[z]) {
+			zones[z] = true
+			nodes = append(nodes, node)
+		} else {
+			rest = append(rest, node)
+		}
+	}
+	return append(nodes, rest[:n-len(nodes)]...)
+}
+
+// updateWeights 把 Update 的参数转换为 SetWeights 的参数，weight 返回节点当前的权重，不存在的节点返回 0。
+// 已经存在的节点保持原来的权重，同时出现在 removed 和 added 中的节点以权重 1 重新加入。
+func updateWeights(added, removed []string, weight func(key string) int) map[string]int {
+	weights := make(map[string]int, len(added)+len(removed))
+	for _, key := range removed {
+		weights[key] = 0
+	}
+	for _, key := range added {
+		if _, ok := weights[key]; ok {
+			weights[key] = 1
+		} else {
+			weights[key] = max(weight(key), 1)
+		}
+	}
+	return weights
+}
+
+// nodeSet 记录节点的顺序和权重，Rendezvous、Jump 和 Maglev 在成员变化时根据它重建查找结构。
+type nodeSet struct {
+	mu      sync.Mutex // 串行化写操作
+	order   []string   // 新节点加在末尾
+	weights map[string]int
+}
+
+// weight 返回节点当前的权重，不存在的节点返回 0，调用方需要持有 mu。
+func (s *nodeSet) weight(key string) int {
+	return s.weights[key]
+}
+
+// apply 修改节点的权重，返回修改后的节点顺序，权重都没有变化时 changed 为 false，调用方需要持有 mu。
+func (s *nodeSet) apply(weights map[string]int) (order []string, changed bool) {
+	if s.weights == nil {
+		s.weights = make(map[string]int)
+	}
+	// 按名称的顺序处理，使结果与 map 的遍历顺序无关
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		weight := weights[key]
+		old, ok := s.weights[key]
+		if max(weight, 0) == old {
+			continue
+		}
+		changed = true
+		switch {
+		case weight > 0 && !ok:
+			s.order = append(s.order, key)
+			s.weights[key] = weight
+		case weight > 0:
+			s.weights[key] = weight
+		default:
+			delete(s.weights, key)
+			i := slices.Index(s.order, key)
+			s.order = slices.Delete(s.order, i, i+1)
+		}
+	}
+	return slices.Clone(s.order), changed
+}
+
+// slotTable 是 Jump 的桶或者 Maglev 的查找表的快照，每个位置保存一个节点，nodes 是不同节点的数量。
+type slotTable struct {
+	slots []string
+	nodes int
+}
+
+// distinct 从 slots[start] 开始循环地向后查找，返回最多 n 个不同的节点。
+func (t *slotTable) distinct(start, n int) []string {
+	nodes := make([]string, 0, min(n, t.nodes))
+	for i := 0; i < len(t.slots) && len(nodes) < cap(nodes); i++ {
+		if node := t.slots[(start+i)%len(t.slots)]; !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// mix 是 splitmix64 的混合函数，用于把 32 位的哈希值扩展为分布均匀的 64 位值。
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
@@ -0,0 +1,132 @@
+package consistenthash
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestPlacementConcurrent 在修改成员和权重的同时并发地查询，使用 go test -race 运行时检查数据竞争。
+// 基础节点始终在环上，因此每次查询都必须返回一个节点，GetN 返回的节点互不相同。
+func TestPlacementConcurrent(t *testing.T) {
+	base := []string{"base-0", "base-1", "base-2"}
+	for _, kind := range Placements {
+		t.Run(kind, func(t *testing.T) {
+			p, err := NewPlacement(kind, 10, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kind == "maglev" {
+				// 默认大小的查找表在 -race 下重建太慢
+				p = NewMaglev(1009, nil)
+			}
+			p.Add(base...)
+			if m, ok := p.(*Map); ok {
+				m.SetLoadFactor(0.25)
+			}
+
+			var readers, writers sync.WaitGroup
+			stop := make(chan struct{})
+			for w := 0; w < 2; w++ {
+				writers.Add(1)
+				go func(w int) {
+					defer writers.Done()
+					for i := 0; i < 50; i++ {
+						extra := "extra-" + strconv.Itoa(w) + "-" + strconv.Itoa(i%5)
+						p.Update([]string{extra}, []string{"extra-" + strconv.Itoa(w) + "-" + strconv.Itoa((i+2)%5)})
+						p.SetWeights(map[string]int{base[i%len(base)]: 1 + i%3})
+						p.Remove(extra)
+					}
+				}(w)
+			}
+			for r := 0; r < 4; r++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						key := "key-" + strconv.Itoa(i)
+						node := p.Get(key)
+						if node == "" {
+							t.Errorf("Get(%s) returned no node", key)
+							return
+						}
+						if m, ok := p.(*Map); ok {
+							m.Inc(node)
+							m.Done(node)
+						}
+						nodes := p.GetN(key, 3)
+						if len(nodes) < len(base) {
+							t.Errorf("GetN(%s, 3) = %v", key, nodes)
+							return
+						}
+						seen := make(map[string]bool)
+						for _, n := range nodes {
+							if seen[n] {
+								t.Errorf("GetN(%s, 3) = %v has duplicates", key, nodes)
+								return
+							}
+							seen[n] = true
+						}
+					}
+				}()
+			}
+			writers.Wait()
+			close(stop)
+			readers.Wait()
+		})
+	}
+}
+
+// TestGetNByZone 检查可用区足够时副本位于不同的可用区，不够时用其他可用区的节点补足 n 个不同的节点。
+func TestGetNByZone(t *testing.T) {
+	zones := map[string]string{
+		"a-0": "a", "a-1": "a", "a-2": "a",
+		"b-0": "b", "b-1": "b",
+		"c-0": "c",
+	}
+	zone := func(node string) string { return zones[node] }
+	for _, kind := range Placements {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlacement(t, kind)
+			for node := range zones {
+				p.Add(node)
+			}
+			for i := 0; i < 1000; i++ {
+				key := "key-" + strconv.Itoa(i)
+				got := GetNByZone(p, key, 3, zone)
+				if len(got) != 3 || got[0] != p.Get(key) {
+					t.Fatalf("GetNByZone(%s, 3) = %v, want 3 nodes starting with the owner %s", key, got, p.Get(key))
+				}
+				seen := make(map[string]bool)
+				for _, node := range got {
+					seen[zone(node)] = true
+				}
+				if len(seen) != 3 {
+					t.Fatalf("GetNByZone(%s, 3) = %v, want one node in each zone", key, got)
+				}
+
+				// 只有 3 个可用区，其余的副本位于已经使用过的可用区
+				got = GetNByZone(p, key, 5, zone)
+				nodes := make(map[string]bool)
+				seen = make(map[string]bool)
+				for j, node := range got {
+					nodes[node] = true
+					if j < 3 {
+						seen[zone(node)] = true
+					}
+				}
+				if len(got) != 5 || len(nodes) != 5 || len(seen) != 3 {
+					t.Fatalf("GetNByZone(%s, 5) = %v, want 5 distinct nodes, the first 3 in distinct zones", key, got)
+				}
+				if got := GetNByZone(p, key, 10, zone); len(got) != len(zones) {
+					t.Fatalf("GetNByZone(%s, 10) = %v, want all %d nodes", key, got, len(zones))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Quality 是选择节点算法的评估结果。
+type Quality struct {
+	Kind        string
+	Nodes       int
+	Keys        int
+	MaxLoad     float64       // 负载最高的节点的 key 数与平均值之比，理想值为 1
+	StdDev      float64       // 各节点 key 数的标准差与平均值之比，理想值为 0
+	MovedAdd    float64       // 增加一个节点时迁移的 key 的比例，理想值为 1/(Nodes+1)
+	MovedRemove float64       // 删除一个节点时迁移的 key 的比例，理想值为 1/Nodes
+	GetTime     time.Duration // 每次 Get 的平均耗时
+}
+
+func (q Quality) String() string {
+	return fmt.Sprintf("%-10s nodes=%d keys=%d max/avg=%.3f stddev=%.3f moved(add)=%.4f (ideal %.4f) moved(remove)=%.4f (ideal %.4f) get=%s",
+		q.Kind, q.Nodes, q.Keys, q.MaxLoad, q.StdDev,
+		q.MovedAdd, 1/float64(q.Nodes+1), q.MovedRemove, 1/float64(q.Nodes), q.GetTime)
+}
+
+// Evaluate 评估名为 kind 的算法在 nodes 个节点、keys 个 key 下的均衡性、成员变化时的迁移比例和查询耗时。
+// 哈希环使用 replicas 倍虚拟节点。删除节点时删除的是第一个加入的节点。
+func Evaluate(kind string, replicas, nodes, keys int) (Quality, error) {
+	if nodes <= 0 || keys <= 0 {
+		return Quality{}, fmt.Errorf("consistenthash: nodes and keys must be positive")
+	}
+	p, err := NewPlacement(kind, replicas, nil)
+	if err != nil {
+		return Quality{}, err
+	}
+	names := make([]string, nodes+1)
+	for i := range names {
+		names[i] = "node-" + strconv.Itoa(i)
+	}
+	p.Add(names[:nodes]...)
+
+	q := Quality{Kind: kind, Nodes: nodes, Keys: keys}
+	owners := make([]string, keys)
+	counts := make(map[string]int, nodes)
+	start := time.Now()
+	for i := range owners {
+		owners[i] = p.Get("key-" + strconv.Itoa(i))
+		counts[owners[i]]++
+	}
+	q.GetTime = time.Since(start) / time.Duration(keys)
+
+	avg := float64(keys) / float64(nodes)
+	var sum float64
+	for _, name := range names[:nodes] {
+		c := float64(counts[name])
+		q.MaxLoad = max(q.MaxLoad, c/avg)
+		sum += (c - avg) * (c - avg)
+	}
+	q.StdDev = math.Sqrt(sum/float64(nodes)) / avg
+
+	p.Add(names[nodes])
+	q.MovedAdd = moved(p, owners)
+	p.Remove(names[nodes])
+	p.Remove(names[0])
+	q.MovedRemove = moved(p, owners)
+	return q, nil
+}
+
+// moved 返回所属节点与 owners 不同的 key 的比例。
+func moved(p Placement, owners []string) float64 {
+	n := 0
+	for i, owner := range owners {
+		if p.Get("key-"+strconv.Itoa(i)) != owner {
+			n++
+		}
+	}
+	return float64(n) / float64(len(owners))
+}
@@ -0,0 +1,99 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestEvaluate 检查所有算法的评估结果在合理的范围内：负载均衡，删除节点时只迁移被删除节点上的 key。
+func TestEvaluate(t *testing.T) {
+	const nodes, keys = 10, 20000
+	for _, kind := range Placements {
+		t.Run(kind, func(t *testing.T) {
+			q, err := Evaluate(kind, 100, nodes, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log(q)
+			if q.Kind != kind || q.Nodes != nodes || q.Keys != keys {
+				t.Fatalf("Evaluate returned %+v", q)
+			}
+			if q.MaxLoad < 1 || q.MaxLoad > 1.5 {
+				t.Errorf("max/avg = %.3f, want within [1, 1.5]", q.MaxLoad)
+			}
+			if q.StdDev > 0.2 {
+				t.Errorf("stddev = %.3f, want at most 0.2", q.StdDev)
+			}
+			if q.MovedAdd <= 0 || q.MovedAdd > 1 {
+				t.Errorf("moved(add) = %.4f, want within (0, 1]", q.MovedAdd)
+			}
+			// 哈希环、rendezvous 和 Maglev 删除一个节点时只迁移它上面的 key，Maglev 允许少量额外的迁移
+			if kind != "jump" && q.MovedRemove > 1.5/nodes {
+				t.Errorf("moved(remove) = %.4f, want about %.4f", q.MovedRemove, 1.0/nodes)
+			}
+		})
+	}
+}
+
+func TestEvaluateInvalid(t *testing.T) {
+	if _, err := Evaluate("ring", 10, 0, 100); err == nil {
+		t.Error("Evaluate with no nodes succeeded")
+	}
+	if _, err := Evaluate("unknown", 10, 3, 100); err == nil {
+		t.Error("Evaluate with an unknown kind succeeded")
+	}
+}
+
+// TestJumpOrder 检查 Jump 的映射只与节点集合有关，与加入和删除的顺序无关。
+func TestJumpOrder(t *testing.T) {
+	a := NewJump(nil)
+	a.Add("node-a", "node-b", "node-c")
+	b := NewJump(nil)
+	b.Add("node-c", "node-x")
+	b.Add("node-a")
+	b.Remove("node-x")
+	b.Add("node-b")
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if x, y := a.Get(key), b.Get(key); x != y {
+			t.Fatalf("Get(%s) = %s and %s for the same nodes", key, x, y)
+		}
+	}
+}
+
+// BenchmarkEvaluate 评估每种算法，结果通过 b.ReportMetric 输出，便于与 go test -bench 的其他结果比较。
+func BenchmarkEvaluate(b *testing.B) {
+	for _, kind := range Placements {
+		b.Run(kind, func(b *testing.B) {
+			var q Quality
+			for i := 0; i < b.N; i++ {
+				var err error
+				if q, err = Evaluate(kind, 100, 10, 10000); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(q.MaxLoad, "max/avg")
+			b.ReportMetric(q.StdDev, "stddev")
+			b.ReportMetric(q.MovedAdd, "moved(add)")
+			b.ReportMetric(q.MovedRemove, "moved(remove)")
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, kind := range Placements {
+		b.Run(kind, func(b *testing.B) {
+			p, err := NewPlacement(kind, 100, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				p.Add("node-" + strconv.Itoa(i))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Get("key-" + strconv.Itoa(i))
+			}
+		})
+	}
+}
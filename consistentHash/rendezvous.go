@@ -0,0 +1,107 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// Rendezvous 是最高随机权重哈希（HRW）：对每个节点计算 key 和节点组合后的分数，选择分数最高的节点。
+// 增加或删除节点时只有分数最高的节点发生变化的 key 会迁移，不需要虚拟节点，但 Get 的耗时与节点数成正比。
+// 带权重时分数是 -weight / ln(h)，h 是均匀分布在 (0, 1) 上的哈希值，节点被选中的概率与权重成正比。
+type Rendezvous struct {
+	hash  Hash
+	nodes nodeSet
+	state atomic.Pointer[[]rendezvousNode]
+}
+
+type rendezvousNode struct {
+	name   string
+	hash   uint64
+	weight float64
+}
+
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	r := &Rendezvous{hash: fn}
+	r.state.Store(&[]rendezvousNode{})
+	return r
+}
+
+func (r *Rendezvous) Add(nodes ...string)    { r.Update(nodes, nil) }
+func (r *Rendezvous) Remove(nodes ...string) { r.Update(nil, nodes) }
+
+func (r *Rendezvous) Update(added, removed []string) {
+	r.nodes.mu.Lock()
+	defer r.nodes.mu.Unlock()
+	r.setWeights(updateWeights(added, removed, r.nodes.weight))
+}
+
+func (r *Rendezvous) SetWeights(weights map[string]int) {
+	r.nodes.mu.Lock()
+	defer r.nodes.mu.Unlock()
+	r.setWeights(weights)
+}
+
+// setWeights 是 SetWeights 的实现，调用方需要持有 r.nodes.mu。
+func (r *Rendezvous) setWeights(weights map[string]int) {
+	order, changed := r.nodes.apply(weights)
+	if !changed {
+		return
+	}
+	next := make([]rendezvousNode, len(order))
+	for i, name := range order {
+		next[i] = rendezvousNode{
+			name:   name,
+			hash:   mix(uint64(r.hash([]byte(name)))),
+			weight: float64(r.nodes.weights[name]),
+		}
+	}
+	r.state.Store(&next)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	nodes := *r.state.Load()
+	h := mix(uint64(r.hash([]byte(key))))
+	var best string
+	bestScore := math.Inf(-1)
+	for _, n := range nodes {
+		if score := n.score(h); score > bestScore {
+			best, bestScore = n.name, score
+		}
+	}
+	return best
+}
+
+// GetN 返回分数最高的 n 个节点。
+func (r *Rendezvous) GetN(key string, n int) []string {
+	nodes := *r.state.Load()
+	if n <= 0 || len(nodes) == 0 {
+		return nil
+	}
+	h := mix(uint64(r.hash([]byte(key))))
+	scores := make([]float64, len(nodes))
+	order := make([]int, len(nodes))
+	for i, node := range nodes {
+		scores[i] = node.score(h)
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	names := make([]string, min(n, len(nodes)))
+	for i := range names {
+		names[i] = nodes[order[i]].name
+	}
+	return names
+}
+
+// score 返回节点对哈希值为 h 的 key 的分数。
+func (n rendezvousNode) score(h uint64) float64 {
+	// 取 53 位作为 (0, 1) 上的浮点数
+	u := (float64(mix(h^n.hash)>>11) + 0.5) / (1 << 53)
+	return -n.weight / math.Log(u)
+}
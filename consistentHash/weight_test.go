@@ -0,0 +1,136 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestPlacement 创建测试使用的算法，Maglev 使用较小的查找表。
+func newTestPlacement(t *testing.T, kind string) Placement {
+	t.Helper()
+	if kind == "maglev" {
+		return NewMaglev(10007, nil)
+	}
+	p, err := NewPlacement(kind, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// shares 返回 keys 个 key 在各节点上的比例。
+func shares(p Placement, keys int) map[string]float64 {
+	counts := make(map[string]float64)
+	for i := 0; i < keys; i++ {
+		counts[p.Get("key-"+strconv.Itoa(i))]++
+	}
+	for node := range counts {
+		counts[node] /= float64(keys)
+	}
+	return counts
+}
+
+// TestWeightedDistribution 检查每个节点分到的 key 的比例与权重成正比。
+func TestWeightedDistribution(t *testing.T) {
+	weights := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}
+	const total = 10
+	for _, kind := range Placements {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlacement(t, kind)
+			p.SetWeights(weights)
+			got := shares(p, 100000)
+			for node, w := range weights {
+				want := float64(w) / total
+				if math.Abs(got[node]-want) > 0.2*want {
+					t.Errorf("node %s (weight %d): share %.3f, want %.3f ± 20%%", node, w, got[node], want)
+				}
+			}
+		})
+	}
+}
+
+// TestAddKeepsWeight 检查 Add 和 Update 不会把已经存在的节点的权重重置为 1。
+func TestAddKeepsWeight(t *testing.T) {
+	for _, kind := range Placements {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlacement(t, kind)
+			p.SetWeights(map[string]int{"a": 1, "b": 4})
+			before := shares(p, 20000)
+			p.Add("b")
+			p.Update([]string{"a", "b", "c"}, nil)
+			p.Remove("c")
+			after := shares(p, 20000)
+			if before["b"] != after["b"] {
+				t.Fatalf("share of b changed from %.3f to %.3f", before["b"], after["b"])
+			}
+			if after["b"] < 0.6 {
+				t.Fatalf("share of b with weight 4 is %.3f", after["b"])
+			}
+		})
+	}
+}
+
+// TestSetWeightsUnchanged 检查权重没有变化时不会替换快照。
+func TestSetWeightsUnchanged(t *testing.T) {
+	weights := map[string]int{"a": 1, "b": 2}
+
+	m := New(10, nil)
+	m.SetWeights(weights)
+	ring := m.ring.Load()
+	m.SetWeights(weights)
+	m.Add("a", "b")
+	m.Remove("c")
+	if m.ring.Load() != ring {
+		t.Error("ring: snapshot replaced without a change")
+	}
+
+	r := NewRendezvous(nil)
+	r.SetWeights(weights)
+	state := r.state.Load()
+	r.SetWeights(weights)
+	r.Add("a")
+	if r.state.Load() != state {
+		t.Error("rendezvous: snapshot replaced without a change")
+	}
+
+	j := NewJump(nil)
+	j.SetWeights(weights)
+	buckets := j.buckets.Load()
+	j.SetWeights(weights)
+	j.Add("b")
+	if j.buckets.Load() != buckets {
+		t.Error("jump: snapshot replaced without a change")
+	}
+
+	g := NewMaglev(1009, nil)
+	g.SetWeights(weights)
+	table := g.table.Load()
+	g.SetWeights(weights)
+	g.Add("a")
+	if g.table.Load() != table {
+		t.Error("maglev: snapshot replaced without a change")
+	}
+}
+
+// TestCollisionOrder 检查虚拟节点哈希冲突时，哈希环与节点加入的顺序无关。
+func TestCollisionOrder(t *testing.T) {
+	// 只使用虚拟节点编号计算哈希，使所有节点的同号虚拟节点都冲突
+	hash := func(data []byte) uint32 {
+		n, _ := strconv.Atoi(strings.TrimRight(string(data), "abcdefghijklmnopqrstuvwxyz"))
+		return uint32(n)
+	}
+	a := New(10, hash)
+	a.SetWeights(map[string]int{"node-a": 1, "node-b": 1, "node-c": 1})
+	b := New(10, hash)
+	b.Add("node-c")
+	b.Add("node-b")
+	b.Add("node-a")
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		if x, y := a.Get(key), b.Get(key); x != y {
+			t.Fatalf("Get(%s) = %s and %s for the same nodes", key, x, y)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package distributecache
+
+import (
+	consistenthash "DistributeCache/consistentHash"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version 是节点的版本号，随节点信息一起注册
+const Version = "1.0.0"
+
+// Node 是节点注册到服务发现中的信息，路由时可以据此选择节点，例如按 Weight 分配数据、优先选择同一个 Zone 的节点。
+type Node struct {
+	Addr          string   `json:"addr"`                     // RPC 地址，形如 tcp@localhost:1234
+	Protocol      string   `json:"protocol,omitempty"`       // tcp 或 tls
+	Weight        int      `json:"weight,omitempty"`         // 相对权重，0 视为 1
+	Zone          string   `json:"zone,omitempty"`           // 可用区
+	Rack          string   `json:"rack,omitempty"`           // 机架
+	Version       string   `json:"version,omitempty"`        // 节点的版本号
+	Codecs        []string `json:"codecs,omitempty"`         // 支持的编解码方式，例如 application/gob
+	CapacityBytes int64    `json:"capacity_bytes,omitempty"` // 本地缓存的容量
+}
+
+func (n Node) equal(o Node) bool {
+	return n.Addr == o.Addr && n.Protocol == o.Protocol && n.Weight == o.Weight &&
+		n.Zone == o.Zone && n.Rack == o.Rack && n.Version == o.Version &&
+		slices.Equal(n.Codecs, o.Codecs) && n.CapacityBytes == o.CapacityBytes
+}
+
+// decodeNode 解析注册的节点信息，兼容旧版本节点只注册 RPC 地址的格式。
+func decodeNode(value []byte) (Node, error) {
+	var n Node
+	if len(value) == 0 || value[0] != '{' {
+		return Node{Addr: string(value)}, nil
+	}
+	err := json.Unmarshal(value, &n)
+	return n, err
+}
+
+// Member 是集群中的一个节点，ID 全局唯一。
+type Member struct {
+	ID string `json:"id"`
+	Node
+}
+
+// newMember 返回只有地址的成员，用于不记录节点信息的服务发现。
+func newMember(id, addr string) Member {
+	return Member{ID: id, Node: Node{Addr: addr}}
+}
+
+// Discovery 是服务发现的接口，节点通过 Register 和 Deregister 加入和离开集群，
+// API 服务器等调用方通过 Watch 获取集群成员的变化。
+// Watch 返回的 channel 每次发送当前所有成员的快照（按 ID 排序），第一次发送的是订阅时的成员，
+// ctx 结束后 channel 被关闭。
+type Discovery interface {
+	Register(ctx context.Context, m Member) error
+	Deregister(ctx context.Context, m Member) error
+	Watch(ctx context.Context) (<-chan []Member, error)
+}
+
+// sortMembers 按 ID 排序，使同样的成员总是得到同样的快照。
+func sortMembers(members []Member) []Member {
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+func sameMembers(a, b []Member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || !a[i].equal(b[i].Node) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendSnapshot 发送一个快照，ctx 结束时返回 false。
+func sendSnapshot(ctx context.Context, ch chan<- []Member, members []Member) bool {
+	select {
+	case ch <- members:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// NodeSetter 由需要节点信息的组件实现，例如根据权重或可用区选择节点的 PeerPicker 和 API 服务器。
+// SyncPeers 每次收到新的成员快照后调用 SetNodes。
+type NodeSetter interface {
+	SetNodes(members []Member)
+}
+
+// SyncPeers 根据 d 的成员快照更新哈希环 peers，节点按照注册的权重加入哈希环，节点下线时关闭 clients 中到该节点的连接，clients 可以为空，
+// 并把每个快照传给 setters。一直阻塞到 ctx 结束或者 Watch 失败。
+func SyncPeers(ctx context.Context, d Discovery, peers consistenthash.Placement, clients *ClientPool, setters ...NodeSetter) error {
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]int) // 节点地址到权重
+	for members := range ch {
+		next := make(map[string]int, len(members))
+		changes := make(map[string]int)
+		for _, m := range members {
+			weight := max(m.Weight, 1) // 旧版本的节点没有注册权重
+			next[m.Addr] = weight
+			if w, ok := current[m.Addr]; !ok {
+				log.Println("discovery: server added:", m.Addr)
+				changes[m.Addr] = weight
+			} else if w != weight {
+				log.Printf("discovery: server %s weight changed: %d -> %d", m.Addr, w, weight)
+				changes[m.Addr] = weight
+			}
+		}
+		var removed []string
+		for addr := range current {
+			if _, ok := next[addr]; !ok {
+				log.Println("discovery: server removed:", addr)
+				changes[addr] = 0
+				removed = append(removed, addr)
+			}
+		}
+		// 一次性替换哈希环，避免并发的查询看到只更新了一部分的环
+		peers.SetWeights(changes)
+		if clients != nil {
+			for _, addr := range removed {
+				clients.Remove(addr)
+			}
+		}
+		current = next
+		for _, s := range setters {
+			s.SetNodes(members)
+		}
+	}
+	return ctx.Err()
+}
+
+// StaticDiscovery 使用固定的成员列表，Register 和 Deregister 不做任何事情。
+type StaticDiscovery struct {
+	members []Member
+}
+
+// NewStaticDiscovery 使用 addrs 作为集群成员，每个成员的 ID 就是它的地址。
+func NewStaticDiscovery(addrs ...string) *StaticDiscovery {
+	members := make([]Member, 0, len(addrs))
+	for _, addr := range addrs {
+		members = append(members, newMember(addr, addr))
+	}
+	return &StaticDiscovery{members: sortMembers(members)}
+}
+
+func (d *StaticDiscovery) Register(ctx context.Context, m Member) error   { return nil }
+func (d *StaticDiscovery) Deregister(ctx context.Context, m Member) error { return nil }
+
+func (d *StaticDiscovery) Watch(ctx context.Context) (<-chan []Member, error) {
+	ch := make(chan []Member, 1)
+	ch <- append([]Member(nil), d.members...)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+const defaultDiscoveryInterval = 5 * time.Second
+
+// FileDiscovery 从文件中读取成员列表，文件变化后发送新的快照。文件由外部维护，Register 和 Deregister 不做任何事情。
+// 文件的每一行是一个成员，格式为 "<ID> <Addr>"、只有 "<Addr>"，或者是包含节点信息的 JSON 对象，
+// 例如 {"id": "1", "addr": "tcp@localhost:1234", "weight": 2}，空行和以 # 开头的行被忽略。
+type FileDiscovery struct {
+	path     string
+	interval time.Duration // 检查文件是否变化的周期
+}
+
+func NewFileDiscovery(path string) *FileDiscovery {
+	return &FileDiscovery{path: path, interval: defaultDiscoveryInterval}
+}
+
+func (d *FileDiscovery) Register(ctx context.Context, m Member) error   { return nil }
+func (d *FileDiscovery) Deregister(ctx context.Context, m Member) error { return nil }
+
+func (d *FileDiscovery) read() ([]Member, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var members []Member
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line[0] == '{' {
+			var m Member
+			if err := json.Unmarshal([]byte(line), &m); err != nil || m.ID == "" || m.Addr == "" {
+				return nil, fmt.Errorf("discovery: invalid line in %s: %q", d.path, line)
+			}
+			members = append(members, m)
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			members = append(members, newMember(fields[0], fields[0]))
+		case 2:
+			members = append(members, newMember(fields[0], fields[1]))
+		default:
+			return nil, fmt.Errorf("discovery: invalid line in %s: %q", d.path, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sortMembers(members), nil
+}
+
+// Watch 读取文件失败时返回错误，之后读取失败只记录日志并保留上一次的快照。
+func (d *FileDiscovery) Watch(ctx context.Context) (<-chan []Member, error) {
+	members, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Member)
+	go func() {
+		defer close(ch)
+		if !sendSnapshot(ctx, ch, members) {
+			return
+		}
+		t := time.NewTicker(d.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			next, err := d.read()
+			if err != nil {
+				log.Println("discovery: read members file error:", err)
+				continue
+			}
+			if sameMembers(members, next) {
+				continue
+			}
+			members = next
+			if !sendSnapshot(ctx, ch, members) {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// HTTPDiscovery 使用内置的 HTTP 注册中心 RPCRegistery，registry 是注册中心的地址，
+// 例如 http://localhost:9999/_geerpc_/registry。注册和心跳请求的请求体是 JSON 编码的 Member，
+// 成员列表通过注册中心带版本号的 /members 接口长轮询获取。
+// registry 可以是逗号分隔的多个地址（主注册中心和备用注册中心），注册和心跳发送到所有地址，
+// 拉取成员列表时使用第一个可用的地址，失败时切换到下一个。
+type HTTPDiscovery struct {
+	registries []string
+	current    atomic.Int32  // 上一次成功拉取成员列表的注册中心下标
+	interval   time.Duration // 心跳的周期，需要小于注册中心的超时时间
+	client     *http.Client
+
+	mu    sync.Mutex
+	beats map[string]context.CancelFunc // 正在发送心跳的节点
+}
+
+func NewHTTPDiscovery(registry string) *HTTPDiscovery {
+	return &HTTPDiscovery{
+		registries: strings.Split(registry, ","),
+		interval:   defaultDiscoveryInterval,
+		client:     &http.Client{},
+		beats:      make(map[string]context.CancelFunc),
+	}
+}
+
+// do 向注册中心发送请求，m 不为空时携带它的地址和 JSON 编码的节点信息，返回响应体。
+func (d *HTTPDiscovery) do(ctx context.Context, method, url string, m *Member, timeout time.Duration) ([]byte, error) {
+	var body io.Reader
+	if m != nil {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil {
+		req.Header.Set("X-Geerpc-Server", m.Addr)
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery: registry returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// broadcast 向所有注册中心发送请求，只要有一个成功就返回 nil。
+func (d *HTTPDiscovery) broadcast(ctx context.Context, method string, m *Member) error {
+	var errs []error
+	for _, registry := range d.registries {
+		if _, err := d.do(ctx, method, registry, m, d.interval); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", registry, err))
+		}
+	}
+	if len(errs) == len(d.registries) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Println("discovery: registry error:", err)
+	}
+	return nil
+}
+
+// Register 向注册中心注册 m，之后定期发送心跳，直到 Deregister。
+func (d *HTTPDiscovery) Register(ctx context.Context, m Member) error {
+	if err := d.broadcast(ctx, http.MethodPost, &m); err != nil {
+		return err
+	}
+	beatCtx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	if old, ok := d.beats[m.Addr]; ok {
+		old()
+	}
+	d.beats[m.Addr] = cancel
+	d.mu.Unlock()
+	go func() {
+		t := time.NewTicker(d.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-beatCtx.Done():
+				return
+			case <-t.C:
+			}
+			if err := d.broadcast(beatCtx, http.MethodPost, &m); err != nil && beatCtx.Err() == nil {
+				log.Println("discovery: heartbeat error:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Deregister 停止发送心跳并从注册中心删除 m。
+func (d *HTTPDiscovery) Deregister(ctx context.Context, m Member) error {
+	d.mu.Lock()
+	if cancel, ok := d.beats[m.Addr]; ok {
+		cancel()
+		delete(d.beats, m.Addr)
+	}
+	d.mu.Unlock()
+	return d.broadcast(ctx, http.MethodDelete, &m)
+}
+
+// Members 返回注册中心的成员列表。wait 不为 0 时使用长轮询，
+// 注册中心的版本号等于 wait 时会阻塞到成员列表变化或者等待超时。
+// 不同注册中心的版本号互不相关，切换注册中心后 wait 与新的版本号不同，请求会立即返回。
+func (d *HTTPDiscovery) Members(ctx context.Context, wait uint64) (Membership, error) {
+	var err error
+	for i := range d.registries {
+		n := (int(d.current.Load()) + i) % len(d.registries)
+		var m Membership
+		if m, err = d.members(ctx, d.registries[n], wait); err == nil {
+			d.current.Store(int32(n))
+			return m, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return Membership{}, err
+}
+
+func (d *HTTPDiscovery) members(ctx context.Context, registry string, wait uint64) (Membership, error) {
+	url, timeout := registry+membersPath, d.interval
+	if wait != 0 {
+		url += "?wait=" + strconv.FormatUint(wait, 10)
+		timeout += defaultWaitTime
+	}
+	body, err := d.do(ctx, http.MethodGet, url, nil, timeout)
+	if err != nil {
+		return Membership{}, err
+	}
+	var m Membership
+	if err := json.Unmarshal(body, &m); err != nil {
+		return Membership{}, fmt.Errorf("discovery: decode members: %w", err)
+	}
+	m.Members = sortMembers(m.Members)
+	return m, nil
+}
+
+// Watch 通过长轮询等待成员列表变化，变化时发送新的快照。拉取失败时保留上一次的快照，退避后重试。
+func (d *HTTPDiscovery) Watch(ctx context.Context) (<-chan []Member, error) {
+	m, err := d.Members(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Member)
+	go func() {
+		defer close(ch)
+		if !sendSnapshot(ctx, ch, m.Members) {
+			return
+		}
+		for failures := 0; ; {
+			next, err := d.Members(ctx, m.Revision)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Println("discovery: fetch members error:", err)
+				if !sleepContext(ctx, retryDelay(failures)) {
+					return
+				}
+				failures++
+				continue
+			}
+			failures = 0
+			changed := !sameMembers(m.Members, next.Members)
+			m = next
+			if changed && !sendSnapshot(ctx, ch, m.Members) {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// SyncRegistry 通过长轮询注册中心 registry 的成员列表，使本地的 peers 与之保持一致，直到 ctx 结束。
+func SyncRegistry(ctx context.Context, registry string, peers consistenthash.Placement) error {
+	return SyncPeers(ctx, NewHTTPDiscovery(registry), peers, nil)
+}
+
+var (
+	_ Discovery = (*StaticDiscovery)(nil)
+	_ Discovery = (*FileDiscovery)(nil)
+	_ Discovery = (*HTTPDiscovery)(nil)
+	_ Discovery = (*EtcdDiscovery)(nil)
+)
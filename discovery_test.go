@@ -0,0 +1,108 @@
+package distributecache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextSnapshot 从 ch 中读取下一个快照，超时时测试失败。
+func nextSnapshot(t *testing.T, ch <-chan []Member) []Member {
+	t.Helper()
+	select {
+	case members, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return members
+	case <-time.After(5 * time.Second):
+		t.Fatal("no snapshot within 5s")
+		return nil
+	}
+}
+
+// describeMembers 把成员列表转换为 "ID=Addr" 的形式，便于比较和输出。
+func describeMembers(members []Member) []string {
+	ids := make([]string, len(members))
+	for i, m := range members {
+		ids[i] = m.ID + "=" + m.Addr
+	}
+	return ids
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	d := NewStaticDiscovery("tcp@b:1", "tcp@a:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := describeMembers(nextSnapshot(t, ch))
+	if len(got) != 2 || got[0] != "tcp@a:1=tcp@a:1" || got[1] != "tcp@b:1=tcp@b:1" {
+		t.Fatalf("snapshot = %v, want the fixed members sorted by ID", got)
+	}
+	// 注册和注销不改变成员列表
+	if err := d.Register(ctx, newMember("c", "tcp@c:1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Deregister(ctx, newMember("tcp@a:1", "tcp@a:1")); err != nil {
+		t.Fatal(err)
+	}
+	if again := describeMembers(nextSnapshotOf(t, d)); len(again) != 2 {
+		t.Fatalf("snapshot after Register/Deregister = %v", again)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("watch channel is still open after ctx is done")
+	}
+}
+
+// nextSnapshotOf 重新订阅 d，返回第一个快照。
+func nextSnapshotOf(t *testing.T, d Discovery) []Member {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nextSnapshot(t, ch)
+}
+
+// TestFileDiscovery 检查成员文件被改写后 Watch 发送新的快照。
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "members")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("# cluster\n1 tcp@a:1\n\ntcp@b:1\n")
+	d := NewFileDiscovery(path)
+	d.interval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeMembers(nextSnapshot(t, ch)); len(got) != 2 || got[0] != "1=tcp@a:1" || got[1] != "tcp@b:1=tcp@b:1" {
+		t.Fatalf("first snapshot = %v", got)
+	}
+
+	write(`{"id": "2", "addr": "tcp@c:1", "weight": 3}` + "\n1 tcp@a:1\n")
+	got := nextSnapshot(t, ch)
+	if ids := describeMembers(got); len(ids) != 2 || ids[0] != "1=tcp@a:1" || ids[1] != "2=tcp@c:1" {
+		t.Fatalf("snapshot after rewrite = %v", ids)
+	}
+	if got[1].Weight != 3 {
+		t.Fatalf("weight = %d, want 3", got[1].Weight)
+	}
+
+	if _, err := NewFileDiscovery(filepath.Join(t.TempDir(), "missing")).Watch(ctx); err == nil {
+		t.Fatal("Watch on a missing file succeeded")
+	}
+}
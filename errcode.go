@@ -0,0 +1,85 @@
+package distributecache
+
+import (
+	"DistributeCache/codec"
+	"context"
+	"errors"
+)
+
+// 节点返回的错误经过 RPC 传递后只剩下错误信息，无法再用 errors.Is 判断。
+// 服务端在响应的 Header.Code 中附带错误码，客户端据此把错误还原为 *RemoteError，
+// 它与错误码对应的错误相等，调用方可以继续使用 errors.Is(err, ErrNotFound) 等方式判断错误的类型。
+
+// ErrNoSuchGroup 表示请求访问的 group 在服务端不存在。
+var ErrNoSuchGroup = errors.New("rpc server: no such group")
+
+// errorCodes 是可以跨 RPC 识别的错误及其错误码。
+var errorCodes = []struct {
+	code string
+	err  error
+}{
+	{"not_found", ErrNotFound},
+	{"conflict", ErrConflict},
+	{"unauthenticated", ErrUnauthenticated},
+	{"permission_denied", ErrPermissionDenied},
+	{"no_such_group", ErrNoSuchGroup},
+	{"deadline_exceeded", context.DeadlineExceeded},
+	{"partial_replication", ErrReplication},
+	{"key_required", ErrKeyRequired},
+}
+
+// errorCode 返回 err 的错误码，不属于 errorCodes 的错误返回空字符串。
+func errorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// setError 把 err 写入响应的 Header，版本冲突时同时写入 key 当前的版本号。
+func setError(h *codec.Header, err error) {
+	h.Error = err.Error()
+	h.Code = errorCode(err)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		h.Version = conflict.Version
+	}
+}
+
+// RemoteError 是服务端返回的错误，Code 为空表示服务端没有给出错误的类型。
+type RemoteError struct {
+	Code    string
+	Message string
+	Version uint64 // Code 为 conflict 时 key 当前的版本号
+}
+
+func remoteError(h *codec.Header) *RemoteError {
+	return &RemoteError{Code: h.Code, Message: h.Error, Version: h.Version}
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Is 判断 e 的错误码是否对应 target。
+func (e *RemoteError) Is(target error) bool {
+	for _, c := range errorCodes {
+		if c.err == target {
+			return e.Code == c.code
+		}
+	}
+	return false
+}
+
+// As 把版本冲突转换为 *ConflictError，使调用方可以像处理本地错误一样通过 errors.As 或 ConflictVersion 得到当前的版本号。
+// 错误码中不包含 key，得到的 ConflictError.Key 为空。
+func (e *RemoteError) As(target any) bool {
+	conflict, ok := target.(**ConflictError)
+	if !ok || e.Code != errorCode(ErrConflict) {
+		return false
+	}
+	*conflict = &ConflictError{Version: e.Version}
+	return true
+}
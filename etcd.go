@@ -3,62 +3,390 @@ package distributecache
 import (
 	consistenthash "DistributeCache/consistentHash"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
-func RegisterServer(etcdClient *clientv3.Client, server Server, ttl int64) {
-	fmt.Println("Registering server:", server.ID)
-	// 创建租约
-	leaseGrantResp, err := etcdClient.Grant(context.Background(), ttl)
-	if err != nil {
-		log.Fatalf("failed to grant lease: %v", err)
+// RegistrarState 是 EtcdRegistrar 的状态
+type RegistrarState int32
+
+const (
+	RegistrarConnecting RegistrarState = iota // 正在注册，或者租约丢失后正在重新注册
+	RegistrarRegistered                       // 已注册，租约正常续期
+	RegistrarStopped                          // 已停止，注册信息已删除
+)
+
+func (s RegistrarState) String() string {
+	switch s {
+	case RegistrarConnecting:
+		return "connecting"
+	case RegistrarRegistered:
+		return "registered"
+	case RegistrarStopped:
+		return "stopped"
 	}
-	leaseID := leaseGrantResp.ID
+	return fmt.Sprintf("RegistrarState(%d)", int32(s))
+}
 
-	// 注册服务器信息
-	_, err = etcdClient.Put(context.Background(), "/servers/"+server.ID, server.Addr, clientv3.WithLease(leaseID))
-	if err != nil {
-		log.Fatalf("failed to put server info: %v", err)
+// EtcdRegistrar 把一个节点注册到 etcd，并在节点运行期间保持注册。
+// 注册信息绑定在 etcd session 的租约上，session 在后台续约；etcd 不可用导致租约丢失后，
+// 以指数退避的方式重试，直到重新注册成功。Stop 撤销租约，注册信息随之被删除。
+type EtcdRegistrar struct {
+	client *clientv3.Client
+	member Member
+	ttl    int64
+
+	state  atomic.Int32
+	mu     sync.Mutex
+	lease  clientv3.LeaseID // 当前 session 的租约，未注册时为 0
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewEtcdRegistrar(client *clientv3.Client, m Member, ttl int64) *EtcdRegistrar {
+	return &EtcdRegistrar{client: client, member: m, ttl: ttl}
+}
+
+// State 返回当前的状态
+func (r *EtcdRegistrar) State() RegistrarState {
+	return RegistrarState(r.state.Load())
+}
+
+// Start 在后台开始注册，只能调用一次。
+func (r *EtcdRegistrar) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx)
+}
+
+// Stop 停止续约并撤销租约，使其他节点立即看到该节点下线。
+// 撤销失败时租约会在 ttl 秒后自动过期。没有调用过 Start 时只修改状态。
+func (r *EtcdRegistrar) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		r.state.Store(int32(RegistrarStopped))
+		return nil
 	}
+	r.cancel()
+	<-r.done
+	r.mu.Lock()
+	lease := r.lease
+	r.lease = 0
+	r.mu.Unlock()
+	r.state.Store(int32(RegistrarStopped))
+	if lease == 0 {
+		return nil
+	}
+	_, err := r.client.Revoke(ctx, lease)
+	return err
+}
 
-	// 保持租约活跃
-	keepAliveChan, err := etcdClient.KeepAlive(context.Background(), leaseID)
+func (r *EtcdRegistrar) run(ctx context.Context) {
+	defer close(r.done)
+	for n := 0; ; n++ {
+		session, err := r.register(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("etcd: register server %s error: %v, retry %d", r.member.ID, err, n+1)
+			if !sleepContext(ctx, retryDelay(n)) {
+				return
+			}
+			continue
+		}
+		n = -1
+		r.state.Store(int32(RegistrarRegistered))
+		log.Printf("etcd: server %s registered with lease %x", r.member.ID, session.Lease())
+		select {
+		case <-ctx.Done():
+			return
+		case <-session.Done():
+			// 续约失败（例如 etcd 长时间不可用，租约已经过期），重新创建 session 注册
+			log.Printf("etcd: lease of server %s lost, registering again", r.member.ID)
+			r.state.Store(int32(RegistrarConnecting))
+			r.mu.Lock()
+			r.lease = 0
+			r.mu.Unlock()
+		}
+	}
+}
+
+// register 创建新的 session 并写入注册信息。
+func (r *EtcdRegistrar) register(ctx context.Context) (*concurrency.Session, error) {
+	r.state.Store(int32(RegistrarConnecting))
+	timeout := time.Duration(r.ttl) * time.Second
+	gctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	lease, err := r.client.Grant(gctx, r.ttl)
+	if err != nil {
+		return nil, fmt.Errorf("grant lease: %w", err)
+	}
+	// session 的续约协程随 ctx 结束，而不是随本次注册的超时结束
+	session, err := concurrency.NewSession(r.client, concurrency.WithLease(lease.ID), concurrency.WithTTL(int(r.ttl)), concurrency.WithContext(ctx))
+	if err != nil {
+		// 租约已经创建，不撤销的话要等 ttl 秒后才会过期
+		r.revoke(lease.ID)
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	r.mu.Lock()
+	r.lease = lease.ID
+	r.mu.Unlock()
+	value, err := json.Marshal(r.member.Node)
 	if err != nil {
-		log.Fatalf("failed to keep alive lease: %v", err)
+		return nil, err
+	}
+	if _, err := r.client.Put(gctx, etcdPrefix+r.member.ID, string(value), clientv3.WithLease(lease.ID)); err != nil {
+		session.Orphan()
+		r.revoke(lease.ID)
+		r.mu.Lock()
+		r.lease = 0
+		r.mu.Unlock()
+		return nil, fmt.Errorf("put server: %w", err)
+	}
+	return session, nil
+}
+
+// revoke 撤销注册失败时创建的租约，最多等待 ttl 秒。撤销失败时租约同样会在 ttl 秒后过期。
+func (r *EtcdRegistrar) revoke(lease clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+	defer cancel()
+	_, _ = r.client.Revoke(ctx, lease)
+}
+
+// WatchServers 根据 etcd 中注册的节点持续更新哈希环 peers。
+// etcd 的删除事件不携带值，因此节点按 /servers/<ID> 中的 ID 跟踪，删除时根据 ID 找到原来的地址，参见 EtcdDiscovery.Watch。
+// 监听失败时退避重试，成功收到成员列表后重置退避时间。
+func WatchServers(etcdClient *clientv3.Client, peers consistenthash.Placement) {
+	d := NewEtcdDiscovery(etcdClient, 0)
+	for failures := 0; ; failures++ {
+		synced := false
+		err := SyncPeers(context.Background(), d, peers, nil, nodeSetterFunc(func([]Member) { synced = true }))
+		if synced {
+			failures = 0
+		}
+		log.Println("etcd: watch servers error:", err)
+		time.Sleep(retryDelay(failures))
+	}
+}
+
+// nodeSetterFunc 把普通函数适配为 NodeSetter。
+type nodeSetterFunc func(members []Member)
+
+func (f nodeSetterFunc) SetNodes(members []Member) {
+	f(members)
+}
+
+const (
+	minRetryDelay = 100 * time.Millisecond
+	maxRetryDelay = 10 * time.Second
+)
+
+// retryDelay 返回第 n 次（从 0 开始）重试前等待的时间，从 minRetryDelay 开始指数增长，最多为 maxRetryDelay。
+func retryDelay(n int) time.Duration {
+	d := minRetryDelay
+	for i := 0; i < n && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+// sleepContext 等待 d，ctx 先结束时返回 false。
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+const etcdPrefix = "/servers/"
+
+// EtcdDiscovery 使用 etcd 实现 Discovery，每个节点以 /servers/<ID> 为键、JSON 编码的 Node 为值注册，
+// 键绑定在 ttl 秒的租约上，节点异常退出后租约过期，键被自动删除。
+type EtcdDiscovery struct {
+	client *clientv3.Client
+	ttl    int64
+
+	mu         sync.Mutex
+	registrars map[string]*EtcdRegistrar // 每个已注册的节点
+}
+
+func NewEtcdDiscovery(client *clientv3.Client, ttl int64) *EtcdDiscovery {
+	return &EtcdDiscovery{client: client, ttl: ttl, registrars: make(map[string]*EtcdRegistrar)}
+}
+
+// Register 使用 EtcdRegistrar 在后台注册 m 并保持注册，等待第一次注册成功后返回。
+// ctx 先结束时停止注册并返回错误。
+func (d *EtcdDiscovery) Register(ctx context.Context, m Member) error {
+	r := NewEtcdRegistrar(d.client, m, d.ttl)
+	d.mu.Lock()
+	if old, ok := d.registrars[m.ID]; ok {
+		d.mu.Unlock()
+		if old.member.equal(m.Node) {
+			return nil
+		}
+		return fmt.Errorf("etcd: server %s is already registered with address %s", m.ID, old.member.Addr)
 	}
+	d.registrars[m.ID] = r
+	d.mu.Unlock()
+	r.Start()
 
-	for {
+	t := time.NewTicker(50 * time.Millisecond)
+	defer t.Stop()
+	for r.State() != RegistrarRegistered {
 		select {
-		case <-keepAliveChan:
-			// fmt.Println("lease renewal successful")
-			// 租约续期成功，这里可以做一些其他事情，比如检查服务器状态
-		case <-time.After(time.Duration(ttl) * time.Second):
-			// 如果租约续期失败，重新尝试
-			log.Println("lease renewal failed, attempting reconnect...")
-			RegisterServer(etcdClient, server, ttl)
-			return
+		case <-ctx.Done():
+			_ = d.Deregister(context.Background(), m)
+			return ctx.Err()
+		case <-t.C:
 		}
 	}
+	return nil
 }
 
-func WatchServers(etcdClient *clientv3.Client, peers *consistenthash.Map) {
-	for {
-		rch := etcdClient.Watch(context.Background(), "/servers/", clientv3.WithPrefix())
-		for wresp := range rch {
-			for _, ev := range wresp.Events {
-				switch ev.Type {
-				case clientv3.EventTypePut:
-					log.Println("Server added:", string(ev.Kv.Value))
-					peers.Add(string(ev.Kv.Value))
-				case clientv3.EventTypeDelete:
-					log.Println("Server deleted:", string(ev.Kv.Value))
-					peers.Remove(string(ev.Kv.Value))
+// Deregister 停止 m 的注册并删除注册信息。
+func (d *EtcdDiscovery) Deregister(ctx context.Context, m Member) error {
+	d.mu.Lock()
+	r, ok := d.registrars[m.ID]
+	delete(d.registrars, m.ID)
+	d.mu.Unlock()
+	if !ok {
+		_, err := d.client.Delete(ctx, etcdPrefix+m.ID)
+		return err
+	}
+	return r.Stop(ctx)
+}
+
+// State 返回 ID 为 id 的节点的注册状态，没有通过 d 注册时返回 RegistrarStopped。
+func (d *EtcdDiscovery) State(id string) RegistrarState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.registrars[id]; ok {
+		return r.State()
+	}
+	return RegistrarStopped
+}
+
+// load 读取当前注册的所有节点，返回 ID 到节点信息的映射和读取时的 revision。
+func (d *EtcdDiscovery) load(ctx context.Context) (map[string]Node, int64, error) {
+	resp, err := d.client.Get(ctx, etcdPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	servers := make(map[string]Node, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		putServer(servers, kv.Key, kv.Value)
+	}
+	return servers, resp.Header.Revision, nil
+}
+
+// Watch 先读取当前的所有节点，再从读取时的 revision 开始监听变化，保证不会遗漏两者之间的事件。
+// 节点按 ID 跟踪，etcd 的删除事件不携带值，只能根据键中的 ID 删除。
+// 监听出错（例如要监听的 revision 已经被压缩、与 leader 失去联系）时重新读取所有节点，再从新的 revision 继续监听。
+// 连续失败时退避的时间逐渐变长，重新建立监听后重置。
+func (d *EtcdDiscovery) Watch(ctx context.Context) (<-chan []Member, error) {
+	servers, rev, err := d.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []Member)
+	go func() {
+		defer close(ch)
+		members := snapshot(servers)
+		if !sendSnapshot(ctx, ch, members) {
+			return
+		}
+		for failures := 0; ; {
+			err := d.watch(ctx, ch, servers, rev, &members, func() { failures = 0 })
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("etcd: watch servers error:", err, ", reloading")
+			for {
+				if !sleepContext(ctx, retryDelay(failures)) {
+					return
+				}
+				failures++
+				if servers, rev, err = d.load(ctx); err == nil {
+					break
+				}
+				log.Println("etcd: load servers error:", err)
+			}
+			if next := snapshot(servers); !sameMembers(members, next) {
+				members = next
+				if !sendSnapshot(ctx, ch, members) {
+					return
 				}
 			}
 		}
+	}()
+	return ch, nil
+}
+
+// watch 从 rev 之后开始监听变化并更新 servers，每次变化后发送新的快照，监听出错或者 ctx 结束时返回。
+// etcd 确认监听建立后调用 created。
+func (d *EtcdDiscovery) watch(ctx context.Context, ch chan<- []Member, servers map[string]Node, rev int64, members *[]Member, created func()) error {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	wch := d.client.Watch(wctx, etcdPrefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1), clientv3.WithCreatedNotify())
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		if wresp.Created {
+			created()
+			continue
+		}
+		for _, ev := range wresp.Events {
+			id := strings.TrimPrefix(string(ev.Kv.Key), etcdPrefix)
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				putServer(servers, ev.Kv.Key, ev.Kv.Value)
+			case clientv3.EventTypeDelete:
+				delete(servers, id)
+			}
+		}
+		if next := snapshot(servers); !sameMembers(*members, next) {
+			*members = next
+			if !sendSnapshot(ctx, ch, next) {
+				return ctx.Err()
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("etcd: watch channel closed")
+}
+
+// putServer 把 etcd 中的一个节点记录到 servers 中，无法解析的记录被忽略。
+func putServer(servers map[string]Node, key, value []byte) {
+	id := strings.TrimPrefix(string(key), etcdPrefix)
+	node, err := decodeNode(value)
+	if err != nil {
+		log.Printf("etcd: invalid record of server %s: %v", id, err)
+		return
+	}
+	servers[id] = node
+}
+
+func snapshot(servers map[string]Node) []Member {
+	members := make([]Member, 0, len(servers))
+	for id, node := range servers {
+		members = append(members, Member{ID: id, Node: node})
 	}
+	return sortMembers(members)
 }
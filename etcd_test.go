@@ -0,0 +1,327 @@
+package distributecache
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// testEtcd 是测试中内嵌的单节点 etcd，重启时使用相同的数据目录和端口。
+type testEtcd struct {
+	t      *testing.T
+	cfg    *embed.Config
+	server *embed.Etcd
+}
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func startEtcd(t *testing.T) *testEtcd {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	client, peer := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{client}, []url.URL{client}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e := &testEtcd{t: t, cfg: cfg}
+	e.start()
+	t.Cleanup(e.stop)
+	return e
+}
+
+func (e *testEtcd) start() {
+	e.t.Helper()
+	server, err := embed.StartEtcd(e.cfg)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		server.Close()
+		e.t.Fatal("etcd did not become ready")
+	}
+	e.server = server
+	// 重启时沿用已有的数据目录
+	e.cfg.ClusterState = embed.ClusterStateFlagExisting
+}
+
+func (e *testEtcd) stop() {
+	if e.server != nil {
+		e.server.Close()
+		e.server = nil
+	}
+}
+
+func (e *testEtcd) restart() {
+	e.t.Helper()
+	e.stop()
+	e.start()
+}
+
+func (e *testEtcd) client() *clientv3.Client {
+	e.t.Helper()
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{e.cfg.ListenClientUrls[0].String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// waitMembers 等待 ch 发送的快照中的节点 ID 与 ids 相同。
+func waitMembers(t *testing.T, ch <-chan []Member, ids ...string) {
+	t.Helper()
+	timeout := time.After(20 * time.Second)
+	for {
+		select {
+		case members, ok := <-ch:
+			if !ok {
+				t.Fatal("watch channel closed")
+			}
+			if len(members) == len(ids) {
+				match := true
+				for i, m := range members {
+					match = match && m.ID == ids[i]
+				}
+				if match {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for members %v", ids)
+		}
+	}
+}
+
+func TestEtcdDiscoveryWatch(t *testing.T) {
+	e := startEtcd(t)
+	d := NewEtcdDiscovery(e.client(), 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := Member{ID: "a", Node: Node{Addr: "tcp@127.0.0.1:1"}}
+	if err := d.Register(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), a)
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, ch, "a")
+
+	b := Member{ID: "b", Node: Node{Addr: "tcp@127.0.0.1:2"}}
+	if err := d.Register(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), b)
+	waitMembers(t, ch, "a", "b")
+	if err := d.Deregister(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, ch, "b")
+}
+
+// TestEtcdDiscoveryReload 在 etcd 重启前后检查成员列表：重启期间的变化在重新监听或者重新读取后被发送。
+func TestEtcdDiscoveryReload(t *testing.T) {
+	e := startEtcd(t)
+	d := NewEtcdDiscovery(e.client(), 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := Member{ID: "a", Node: Node{Addr: "tcp@127.0.0.1:1"}}
+	if err := d.Register(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), a)
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, ch, "a")
+
+	e.restart()
+	// 重启后由另一个客户端写入，watch 只能通过重新连接或者重新读取看到它
+	other := NewEtcdDiscovery(e.client(), 5)
+	b := Member{ID: "b", Node: Node{Addr: "tcp@127.0.0.1:2"}}
+	if err := other.Register(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Deregister(context.Background(), b)
+	waitMembers(t, ch, "a", "b")
+}
+
+// flakyWatcher 让接下来的若干次 Watch 立即以 ErrCompacted 失败，并记录每次 Watch 的时间。
+type flakyWatcher struct {
+	clientv3.Watcher
+	mu       sync.Mutex
+	failures int
+	cancel   context.CancelFunc // 结束当前正常的监听
+	calls    []time.Time
+}
+
+func (w *flakyWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls = append(w.calls, time.Now())
+	if w.failures > 0 {
+		w.failures--
+		ch := make(chan clientv3.WatchResponse, 1)
+		ch <- clientv3.WatchResponse{CompactRevision: 1}
+		close(ch)
+		return ch
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	return w.Watcher.Watch(ctx, key, opts...)
+}
+
+// fail 结束当前的监听，并让接下来的 n 次 Watch 失败，返回调用的时间。
+func (w *flakyWatcher) fail(n int) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures = n
+	if w.cancel != nil {
+		w.cancel()
+	}
+	return time.Now()
+}
+
+// call 等待第 i 次（从 0 开始）Watch 调用，返回调用的时间。
+func (w *flakyWatcher) call(t *testing.T, i int) time.Time {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w.mu.Lock()
+		if i < len(w.calls) {
+			defer w.mu.Unlock()
+			return w.calls[i]
+		}
+		w.mu.Unlock()
+	}
+	t.Fatalf("timed out waiting for watch call %d", i)
+	return time.Time{}
+}
+
+// TestEtcdDiscoveryRetry 检查监听失败时重新读取节点并退避重试，重新建立监听后退避时间被重置。
+func TestEtcdDiscoveryRetry(t *testing.T) {
+	e := startEtcd(t)
+	client := e.client()
+	w := &flakyWatcher{Watcher: client.Watcher}
+	client.Watcher = w
+	d := NewEtcdDiscovery(client, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := Member{ID: "a", Node: Node{Addr: "tcp@127.0.0.1:1"}}
+	if err := d.Register(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), a)
+
+	// 前三次监听失败，每次重试前等待的时间加倍
+	w.fail(3)
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, ch, "a")
+	b := Member{ID: "b", Node: Node{Addr: "tcp@127.0.0.1:2"}}
+	if err := d.Register(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), b)
+	waitMembers(t, ch, "a", "b")
+	if got := w.call(t, 3).Sub(w.call(t, 2)); got < retryDelay(2) {
+		t.Fatalf("third retry after %v, want at least %v", got, retryDelay(2))
+	}
+
+	// 监听建立之后再次失败，从最短的等待时间重新开始
+	failed := w.fail(1)
+	c := Member{ID: "c", Node: Node{Addr: "tcp@127.0.0.1:3"}}
+	if err := d.Register(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Deregister(context.Background(), c)
+	waitMembers(t, ch, "a", "b", "c")
+	if got := w.call(t, 4).Sub(failed); got >= retryDelay(1) {
+		t.Fatalf("retry after a re-established watch waited %v, want less than %v", got, retryDelay(1))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if got := retryDelay(0); got != minRetryDelay {
+		t.Fatalf("retryDelay(0) = %v, want %v", got, minRetryDelay)
+	}
+	if got := retryDelay(3); got != 8*minRetryDelay {
+		t.Fatalf("retryDelay(3) = %v, want %v", got, 8*minRetryDelay)
+	}
+	if got := retryDelay(100); got != maxRetryDelay {
+		t.Fatalf("retryDelay(100) = %v, want %v", got, maxRetryDelay)
+	}
+}
+
+// waitRegistered 等待 r 注册成功，并且 etcd 中能读到它的注册信息。
+func waitRegistered(t *testing.T, client *clientv3.Client, r *EtcdRegistrar) {
+	t.Helper()
+	for deadline := time.Now().Add(20 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if r.State() != RegistrarRegistered {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		resp, err := client.Get(ctx, etcdPrefix+r.member.ID)
+		cancel()
+		if err == nil && len(resp.Kvs) == 1 {
+			return
+		}
+	}
+	t.Fatalf("server %s is not registered, state %v", r.member.ID, r.State())
+}
+
+func TestEtcdRegistrarRestart(t *testing.T) {
+	e := startEtcd(t)
+	client := e.client()
+	r := NewEtcdRegistrar(client, Member{ID: "a", Node: Node{Addr: "tcp@127.0.0.1:1"}}, 2)
+	r.Start()
+	waitRegistered(t, client, r)
+
+	// etcd 停止的时间超过租约的 ttl，重启后注册信息被恢复
+	e.stop()
+	time.Sleep(3 * time.Second)
+	e.start()
+	waitRegistered(t, client, r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(ctx, etcdPrefix+"a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 0 || r.State() != RegistrarStopped {
+		t.Fatalf("after Stop: %d records, state %v", len(resp.Kvs), r.State())
+	}
+}
+
+func TestEtcdRegistrarStopWithoutStart(t *testing.T) {
+	r := NewEtcdRegistrar(nil, Member{ID: "a"}, 2)
+	if err := r.Stop(context.Background()); err != nil || r.State() != RegistrarStopped {
+		t.Fatalf("Stop = %v, state %v", err, r.State())
+	}
+}
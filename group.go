@@ -2,9 +2,12 @@ package distributecache
 
 import (
 	"DistributeCache/singleflight"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // 定义接口 Getter 和 回调函数 Get(key string)([]byte, error)，参数是 key，返回值是 []byte。
@@ -16,6 +19,13 @@ type Getter interface {
 }
 type GetterFunc func(key string) ([]byte, error)
 
+// ErrNotFound 表示 key 不存在。Getter 在数据源中找不到 key 时应返回包装了 ErrNotFound 的错误，
+// 这样错误经过 RPC 传递后，API 服务器仍然能够据此返回 404。
+var ErrNotFound = errors.New("key not found")
+
+// ErrKeyRequired 表示请求的 key 为空。
+var ErrKeyRequired = errors.New("key is required")
+
 func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
@@ -31,6 +41,20 @@ type Group struct {
 	getter    Getter
 	mainCache cache
 	loader    *singleflight.Group // 避免缓存击穿
+	hits      atomic.Int64        // 缓存命中次数
+	misses    atomic.Int64        // 缓存未命中次数
+	watchers  watchers            // 订阅缓存变更的观察者
+
+	replication atomic.Pointer[Replication] // 复制配置，为空时不复制
+}
+
+// Stats 是 Group 的统计信息。
+type Stats struct {
+	Keys     int   // 本地缓存的条目数
+	Bytes    int64 // 本地缓存已经使用的内存
+	MaxBytes int64 // 本地缓存的容量
+	Hits     int64
+	Misses   int64
 }
 
 var (
@@ -81,16 +105,28 @@ func GetGroup(name string) *Group {
 //	error - 如果获取过程中出现错误，则返回错误；否则返回nil。
 func (g *Group) Get(key string) (ByteView, error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, ErrKeyRequired
 	}
 	if v, ok := g.mainCache.get(key); ok {
 		log.Println("[GeeCache] hit")
+		g.hits.Add(1)
 		return v, nil
 	}
 
+	g.misses.Add(1)
 	return g.load(key)
 }
 
+// lookup 只在本地缓存中查找 key，不会从数据源加载，也不计入命中率。
+func (g *Group) lookup(key string) (ByteView, bool) {
+	return g.mainCache.get(key)
+}
+
+// lookupVersioned 与 lookup 相同，但 key 已经删除时返回删除标记，用于比较各个副本上的版本。
+func (g *Group) lookupVersioned(key string) (ByteView, bool) {
+	return g.mainCache.getVersioned(key)
+}
+
 func (g *Group) load(key string) (value ByteView, err error) {
 	view, err := g.loader.Do(key, func() (interface{}, error) {
 		return g.getLocally(key)
@@ -109,25 +145,131 @@ func (g *Group) getLocally(key string) (ByteView, error) {
 	}
 
 	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
-	return value, err
+	return g.populateCache(key, value), nil
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+func (g *Group) populateCache(key string, value ByteView) ByteView {
+	value, _ = g.mainCache.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		// 替换删除标记时至少继承它的版本号，使删除之前写入的旧值不能通过 Put 覆盖新值
+		if !ok {
+			value.v = max(value.v, old.v)
+		}
+		return value, true
+	})
+	return value
 }
 
 func (g *Group) Insert(key string, value ByteView) {
+	g.insert(key, value)
+}
+
+// insert 为 value 分配新的版本号后写入本地缓存，返回写入的值。
+func (g *Group) insert(key string, value ByteView) ByteView {
 	if key == "" {
 		log.Println("key is required")
 	}
-	g.populateCache(key, value)
+	value.v = newVersion()
+	value = g.populateCache(key, value)
+	g.notify(EventPut, key, value)
+	return value
+}
+
+// Put 按版本号写入 key：已有的值或者删除标记的版本号更大时不写入（后写者胜），返回是否写入。
+// 用于写入从其他节点复制来的值，value 保留原来的版本号，可以是删除标记。
+// 版本号比本地时钟超前太多（参见 HLC.Update）时不写入。
+func (g *Group) Put(key string, value ByteView) bool {
+	stored, err := g.put(key, value)
+	if err != nil {
+		log.Printf("[GeeCache] put %s: %v", key, err)
+	}
+	return stored
+}
+
+// put 是 Put 的实现，版本号被时钟拒绝时返回错误。
+func (g *Group) put(key string, value ByteView) (bool, error) {
+	if err := clock.Update(value.v); err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	stored, ok := g.mainCache.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		return value, old.v <= value.v
+	})
+	switch {
+	case ok && stored.d:
+		g.notify(EventDelete, key, ByteView{})
+	case ok:
+		g.notify(EventPut, key, stored)
+	}
+	return ok, nil
+}
+
+// InsertWithTTL 与 Insert 相同，但缓存值在 ttl 之后过期，ttl 不大于 0 时永不过期。
+func (g *Group) InsertWithTTL(key string, value ByteView, ttl time.Duration) {
+	g.insertWithTTL(key, value, ttl)
+}
+
+// insertWithTTL 是 InsertWithTTL 的实现，返回写入的值。
+func (g *Group) insertWithTTL(key string, value ByteView, ttl time.Duration) ByteView {
+	value.e = time.Time{}
+	if ttl > 0 {
+		value.e = time.Now().Add(ttl)
+	}
+	return g.insert(key, value)
+}
+
+// update 原子地检查并修改本地缓存中 key 的值，参见 cache.update。
+func (g *Group) update(key string, fn func(old ByteView, ok bool) (ByteView, bool)) (ByteView, bool) {
+	value, ok := g.mainCache.update(key, fn)
+	if ok {
+		g.notify(EventPut, key, value)
+	}
+	return value, ok
+}
+
+// Name 返回 Group 的名称
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Stats 返回 Group 的统计信息
+func (g *Group) Stats() Stats {
+	keys, bytes := g.mainCache.stats()
+	return Stats{
+		Keys:     keys,
+		Bytes:    bytes,
+		MaxBytes: g.mainCache.cacheBytes,
+		Hits:     g.hits.Load(),
+		Misses:   g.misses.Load(),
+	}
+}
 
+// tombstoneTTL 是删除标记保留的时间，版本号比删除更旧的复制写入需要在这段时间内到达，否则被删除的值可能复活。
+const tombstoneTTL = 10 * time.Minute
+
+// tombstone 返回一个分配了新版本号的删除标记。
+func tombstone() ByteView {
+	return ByteView{d: true, e: time.Now().Add(tombstoneTTL), v: newVersion()}
 }
 
+// Delete 把 key 替换为删除标记，key 不存在时返回 ErrNotFound。
 func (g *Group) Delete(key string) error {
+	_, err := g.delete(key)
+	return err
+}
+
+// delete 是 Delete 的实现，返回写入的删除标记。key 不存在时同样写入删除标记，
+// 因为副本上可能仍然存在，删除标记需要复制到副本。
+func (g *Group) delete(key string) (ByteView, error) {
 	if key == "" {
 		log.Println("key is required")
 	}
-	return g.mainCache.delete(key)
+	var found bool
+	dead, _ := g.mainCache.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		found = ok
+		return tombstone(), true
+	})
+	if !found {
+		return dead, ErrNotFound
+	}
+	g.notify(EventDelete, key, ByteView{})
+	return dead, nil
 }
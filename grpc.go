@@ -0,0 +1,267 @@
+package distributecache
+
+import (
+	"DistributeCache/cachepb"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer 通过 gRPC 提供缓存服务，接口定义见 cachepb/cache.proto，
+// 其他语言的服务可以据此生成客户端，并使用 gRPC 的超时控制和标准状态码。
+// 它与 Server.Accept 的自定义 RPC 监听不同的地址，访问的是同一个进程中的 Group。
+type GRPCServer struct {
+	cachepb.UnimplementedCacheServer
+	group      string // 请求没有指定 group 时访问的 group
+	srv        *grpc.Server
+	replicator *Replicator // 不为空时把写操作转发到副本节点
+}
+
+// NewGRPCServer 创建 gRPC 服务，opts 用于设置 TLS 证书、拦截器等。
+func NewGRPCServer(defaultGroup string, opts ...grpc.ServerOption) *GRPCServer {
+	s := &GRPCServer{group: defaultGroup, srv: grpc.NewServer(opts...)}
+	cachepb.RegisterCacheServer(s.srv, s)
+	return s
+}
+
+// SetReplicator 设置转发写操作使用的 Replicator，通常与节点的 Server.SetReplicator 相同，需要在 Serve 之前调用。
+func (s *GRPCServer) SetReplicator(r *Replicator) {
+	s.replicator = r
+}
+
+// Serve 在 lis 上处理 gRPC 请求，直到 Stop 被调用或者监听出错。
+func (s *GRPCServer) Serve(lis net.Listener) error {
+	log.Println("grpc server: accept addr:", lis.Addr().String())
+	return s.srv.Serve(lis)
+}
+
+// Stop 等待正在处理的请求结束后关闭服务，Watch 等流式调用会被直接结束。
+func (s *GRPCServer) Stop() {
+	s.srv.GracefulStop()
+}
+
+// gRPC 调用的认证信息放在 metadata 中，字段与握手时 Option 中的同名字段含义相同。
+const (
+	grpcIdentityKey  = "x-cache-identity"
+	grpcTimestampKey = "x-cache-timestamp"
+	grpcNonceKey     = "x-cache-nonce"
+	grpcTokenKey     = "x-cache-token"
+)
+
+// GRPCAuth 返回使用 auth 校验每个调用的拦截器，与 NewGRPCServer 的其他选项一起传入。
+// 认证失败的调用返回 Unauthenticated。
+func GRPCAuth(auth Authenticator) []grpc.ServerOption {
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		get := func(key string) string {
+			if v := md.Get(key); len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		ts, _ := strconv.ParseInt(get(grpcTimestampKey), 10, 64)
+		opt := &Option{Identity: get(grpcIdentityKey), Timestamp: ts, Nonce: get(grpcNonceKey), Token: get(grpcTokenKey)}
+		if _, err := auth.Authenticate(opt); err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// HMACCredentials 是 gRPC 客户端使用的认证信息，每个调用都使用 SignOption 重新签名，
+// 配合服务端的 GRPCAuth(&HMACAuthenticator{...}) 使用。
+type HMACCredentials struct {
+	Identity string
+	Secret   []byte
+}
+
+var _ credentials.PerRPCCredentials = HMACCredentials{}
+
+func (c HMACCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	opt := &Option{}
+	SignOption(opt, c.Identity, c.Secret)
+	return map[string]string{
+		grpcIdentityKey:  opt.Identity,
+		grpcTimestampKey: strconv.FormatInt(opt.Timestamp, 10),
+		grpcNonceKey:     opt.Nonce,
+		grpcTokenKey:     opt.Token,
+	}, nil
+}
+
+// RequireTransportSecurity 返回 false，与自定义 RPC 相同，签名可以在没有 TLS 的连接上使用。
+func (c HMACCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// lookup 返回请求访问的 Group。
+func (s *GRPCServer) lookup(name string) (*Group, error) {
+	if name == "" {
+		name = s.group
+	}
+	g := GetGroup(name)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group %s", name)
+	}
+	return g, nil
+}
+
+// grpcError 把 Group 返回的错误转换为 gRPC 状态码。
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, ErrReplication):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrKeyRequired):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func expireUnixNano(v ByteView) int64 {
+	if v.e.IsZero() {
+		return 0
+	}
+	return v.e.UnixNano()
+}
+
+func (s *GRPCServer) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	g, err := s.lookup(req.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+	view, err := g.Get(req.GetKey())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &cachepb.GetResponse{Value: view.b, ExpireUnixNano: expireUnixNano(view)}, nil
+}
+
+func (s *GRPCServer) Set(ctx context.Context, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+	g, err := s.lookup(req.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrKeyRequired.Error())
+	}
+	if req.GetTtlMs() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl_ms must not be negative")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+	value := g.insertWithTTL(req.GetKey(), ByteView{b: req.GetValue()}, time.Duration(req.GetTtlMs())*time.Millisecond)
+	if err := s.replicator.put(g, req.GetKey(), value); err != nil {
+		return nil, grpcError(err)
+	}
+	return &cachepb.SetResponse{}, nil
+}
+
+func (s *GRPCServer) Delete(ctx context.Context, req *cachepb.DeleteRequest) (*cachepb.DeleteResponse, error) {
+	g, err := s.lookup(req.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrKeyRequired.Error())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, grpcError(err)
+	}
+	dead, err := g.delete(req.GetKey())
+	// 本地不存在时副本上仍然可能存在，同样需要把删除标记复制到副本
+	if rerr := s.replicator.put(g, req.GetKey(), dead); rerr != nil && err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &cachepb.DeleteResponse{}, nil
+}
+
+// MultiGet 依次获取每个 key，不存在的 key 放在 Missing 中而不是返回错误。
+func (s *GRPCServer) MultiGet(ctx context.Context, req *cachepb.MultiGetRequest) (*cachepb.MultiGetResponse, error) {
+	g, err := s.lookup(req.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	resp := &cachepb.MultiGetResponse{Values: make(map[string][]byte, len(req.GetKeys()))}
+	for _, key := range req.GetKeys() {
+		if err := ctx.Err(); err != nil {
+			return nil, grpcError(err)
+		}
+		view, err := g.Get(key)
+		switch {
+		case err == nil:
+			resp.Values[key] = view.b
+		case errors.Is(err, ErrNotFound):
+			resp.Missing = append(resp.Missing, key)
+		default:
+			return nil, grpcError(err)
+		}
+	}
+	return resp, nil
+}
+
+// Watch 把 Group 的变更事件发送给调用方，直到调用方取消。
+// 调用方处理过慢时以 ResourceExhausted 结束，调用方可以重新订阅。
+func (s *GRPCServer) Watch(req *cachepb.WatchRequest, stream cachepb.Cache_WatchServer) error {
+	g, err := s.lookup(req.GetGroup())
+	if err != nil {
+		return err
+	}
+	events, cancel := g.Watch(req.GetPrefix())
+	defer cancel()
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return grpcError(ctx.Err())
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind, events were dropped")
+			}
+			ev := &cachepb.WatchEvent{Key: e.Key}
+			if e.Type == EventDelete {
+				ev.Type = cachepb.WatchEvent_DELETE
+			} else {
+				ev.Type = cachepb.WatchEvent_PUT
+				ev.Value = e.Value.b
+				ev.ExpireUnixNano = expireUnixNano(e.Value)
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package distributecache
+
+import (
+	"DistributeCache/cachepb"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPCServer 启动一个使用 opts 的 gRPC 服务，返回监听地址。
+func startGRPCServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGRPCServer(t.Name(), opts...)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func grpcGet(t *testing.T, addr string, opts ...grpc.DialOption) (string, error) {
+	t.Helper()
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := cachepb.NewCacheClient(conn).Get(ctx, &cachepb.GetRequest{Key: "k"})
+	return string(resp.GetValue()), err
+}
+
+func TestGRPCAuth(t *testing.T) {
+	secret := []byte("secret")
+	addr := startGRPCServer(t, GRPCAuth(&HMACAuthenticator{Secret: secret})...)
+	plain := grpc.WithTransportCredentials(insecure.NewCredentials())
+
+	if _, err := grpcGet(t, addr, plain); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("unsigned call: %v", err)
+	}
+	wrong := grpc.WithPerRPCCredentials(HMACCredentials{Identity: "cli", Secret: []byte("other")})
+	if _, err := grpcGet(t, addr, plain, wrong); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	signed := grpc.WithPerRPCCredentials(HMACCredentials{Identity: "cli", Secret: secret})
+	if value, err := grpcGet(t, addr, plain, signed); err != nil || value != "value of k" {
+		t.Fatalf("signed call = %q, %v", value, err)
+	}
+}
+
+func TestGRPCTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	certFile, keyFile := ca.issue(t, "server", false)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startGRPCServer(t, grpc.Creds(credentials.NewTLS(serverConfig)))
+
+	clientConfig, err := NewClientTLSConfig(ca.file, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := grpcGet(t, addr, grpc.WithTransportCredentials(credentials.NewTLS(clientConfig))); err != nil || value != "value of k" {
+		t.Fatalf("call over TLS = %q, %v", value, err)
+	}
+	if _, err := grpcGet(t, addr, grpc.WithTransportCredentials(insecure.NewCredentials())); err == nil {
+		t.Fatal("expected a plaintext call to a TLS server to fail")
+	}
+}
+
+// TestGRPCError 检查 Group 返回的错误按照 errors.Is 转换为 gRPC 状态码，与错误信息的内容无关。
+func TestGRPCError(t *testing.T) {
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	_, err := g.Get("")
+	if !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("Get with an empty key = %v, want ErrKeyRequired", err)
+	}
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{err, codes.InvalidArgument},
+		{fmt.Errorf("get: %w", ErrKeyRequired), codes.InvalidArgument},
+		{fmt.Errorf("k: %w", ErrNotFound), codes.NotFound},
+		{fmt.Errorf("%w: node down", ErrReplication), codes.Unavailable},
+		{errors.New("key is required but wrapped by hand"), codes.Internal},
+	} {
+		if got := status.Code(grpcError(tc.err)); got != tc.code {
+			t.Errorf("grpcError(%v) = %v, want %v", tc.err, got, tc.code)
+		}
+	}
+}
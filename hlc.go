@@ -0,0 +1,116 @@
+package distributecache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HLC 是混合逻辑时钟（Hybrid Logical Clock），用于给缓存值分配版本号。
+// 版本号沿用雪花算法的时间戳起点和各部分的位数，但把序列号换成逻辑计数并放在节点 ID 之前：
+//
+//	| 41 位毫秒时间戳（相对 epoch） | 12 位逻辑计数 | 10 位节点 ID |
+//
+// 因此版本号按（物理时间，逻辑计数，节点 ID）比较：同一毫秒内的写入由逻辑计数区分，
+// 不同节点同时写入时由节点 ID 决定先后。只有节点 ID 互不相同时，不同节点分配的版本号才不会相同：
+// SetClockNode 由地址哈希得到的节点 ID 只有 10 位，节点较多时可能冲突，此时两个节点可能为不同的值分配相同的版本号，
+// 副本之间无法区分它们，需要唯一性时使用 SetClockNodeID 为每个节点指定不同的 ID。
+// 收到其他节点的版本号时调用 Update，之后分配的版本号一定大于它，即使两个节点的物理时钟存在偏差；
+// 比本地物理时钟超前 MaxClockOffset 以上的版本号被拒绝，避免一个时钟错误的节点使所有节点的版本号跳到未来。
+type HLC struct {
+	mu      sync.Mutex
+	nodeID  int64
+	wall    int64 // 最近一次分配的版本号的毫秒时间戳
+	logical int64 // wall 相同时的逻辑计数
+}
+
+func NewHLC(nodeID int64) (*HLC, error) {
+	if nodeID < 0 || nodeID > maxNodeID {
+		return nil, fmt.Errorf("node id must be between 0 and %d", maxNodeID)
+	}
+	return &HLC{nodeID: nodeID}, nil
+}
+
+// Now 返回一个新的版本号，大于本时钟之前分配以及通过 Update 收到的所有版本号。
+func (h *HLC) Now() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pt := currentTimestamp(); pt > h.wall {
+		h.wall, h.logical = pt, 0
+	} else {
+		h.tick()
+	}
+	return h.version()
+}
+
+// MaxClockOffset 是 Update 接受的版本号比本地物理时钟超前的最大时间。
+const MaxClockOffset = 10 * time.Second
+
+// ErrClockOffset 表示版本号的时间戳比本地物理时钟超前太多。
+var ErrClockOffset = errors.New("version is too far ahead of the local clock")
+
+// Update 合并其他节点分配的版本号 v，v 的时间戳比本地物理时钟超前 MaxClockOffset 以上时不合并，返回 ErrClockOffset。
+func (h *HLC) Update(v uint64) error {
+	wall, logical, _ := SplitVersion(v)
+	pt := currentTimestamp()
+	if offset := time.Duration(wall-pt) * time.Millisecond; offset > MaxClockOffset {
+		return fmt.Errorf("%w: %s ahead", ErrClockOffset, offset)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case pt > h.wall && pt > wall:
+		h.wall, h.logical = pt, 0
+	case wall > h.wall:
+		h.wall, h.logical = wall, logical
+		h.tick()
+	case wall == h.wall:
+		h.logical = max(h.logical, logical)
+		h.tick()
+	}
+	return nil
+}
+
+// tick 使逻辑计数加一，同一毫秒内的计数用完时借用下一毫秒，调用方需要持有锁。
+func (h *HLC) tick() {
+	if h.logical++; h.logical > maxSequence {
+		h.wall, h.logical = h.wall+1, 0
+	}
+}
+
+func (h *HLC) version() uint64 {
+	return uint64((h.wall-epoch)<<timestampShift | h.logical<<nodeBits | h.nodeID)
+}
+
+// SplitVersion 把版本号拆分为毫秒时间戳（Unix 时间）、逻辑计数和节点 ID。
+func SplitVersion(v uint64) (wall, logical, nodeID int64) {
+	return int64(v>>timestampShift) + epoch, int64(v>>nodeBits) & maxSequence, int64(v) & maxNodeID
+}
+
+// clock 是本进程分配版本号使用的时钟，节点 ID 由 SetClockNode 设置。
+var clock, _ = NewHLC(0)
+
+// SetClockNode 根据节点的 IP 地址和端口号设置版本号中的节点 ID，与雪花算法的节点 ID 相同，需要在处理请求之前调用。
+// 节点 ID 是地址的哈希值，不同的地址可能得到相同的 ID，参见 HLC。
+func SetClockNode(ip, port string) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.nodeID = getNodeID(ip, port)
+}
+
+// SetClockNodeID 设置版本号中的节点 ID，调用方保证每个节点（包括 API 服务器）的 ID 不同，需要在处理请求之前调用。
+func SetClockNodeID(id int64) error {
+	if id < 0 || id > maxNodeID {
+		return fmt.Errorf("node id must be between 0 and %d", maxNodeID)
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.nodeID = id
+	return nil
+}
+
+// newVersion 返回新写入的值的版本号。
+func newVersion() uint64 {
+	return clock.Now()
+}
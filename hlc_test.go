@@ -0,0 +1,58 @@
+package distributecache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// versionAt 返回时间戳为 t 的版本号。
+func versionAt(t time.Time, nodeID int64) uint64 {
+	return uint64((t.UnixMilli()-epoch)<<timestampShift | nodeID)
+}
+
+// TestHLCUpdateMaxOffset 检查时钟拒绝超前太多的版本号，接受时钟偏差范围内的版本号。
+func TestHLCUpdateMaxOffset(t *testing.T) {
+	h, err := NewHLC(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead := versionAt(time.Now().Add(MaxClockOffset/2), 2)
+	if err := h.Update(ahead); err != nil {
+		t.Fatalf("Update within the max offset: %v", err)
+	}
+	if v := h.Now(); v <= ahead {
+		t.Fatalf("Now() = %d, want greater than %d", v, ahead)
+	}
+
+	before := h.Now()
+	future := versionAt(time.Now().Add(time.Hour), 2)
+	if err := h.Update(future); !errors.Is(err, ErrClockOffset) {
+		t.Fatalf("Update an hour ahead = %v, want ErrClockOffset", err)
+	}
+	if v := h.Now(); v >= future {
+		t.Fatalf("Now() = %d jumped to the rejected version", v)
+	} else if v <= before {
+		t.Fatalf("Now() = %d, want greater than %d", v, before)
+	}
+}
+
+// TestPutRejectsFutureVersion 检查 Group.Put 不写入时间戳超前太多的值。
+func TestPutRejectsFutureVersion(t *testing.T) {
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	future := ByteView{b: []byte("future"), v: versionAt(time.Now().Add(time.Hour), 2)}
+	if _, err := g.put("k", future); !errors.Is(err, ErrClockOffset) {
+		t.Fatalf("put = %v, want ErrClockOffset", err)
+	}
+	if _, ok := g.lookup("k"); ok {
+		t.Fatal("a value an hour ahead was stored")
+	}
+}
+
+func TestSetClockNodeID(t *testing.T) {
+	if err := SetClockNodeID(maxNodeID + 1); err == nil {
+		t.Fatal("SetClockNodeID accepted an id out of range")
+	}
+}
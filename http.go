@@ -2,11 +2,16 @@ package distributecache
 
 import (
 	consistenthash "DistributeCache/consistentHash"
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -18,22 +23,33 @@ const (
 
 // 创建具体的 HTTP 客户端类 httpGetter，实现 PeerGetter 接口。
 // baseURL 表示将要访问的远程节点的地址，例如 http://example.com/_geecache/。
-// 使用 http.Get() 方式获取返回值，并转换为 []bytes 类型。
+// 使用 http.Get() 方式获取返回值，并转换为 []bytes 类型；使用 PUT 和 DELETE 请求插入和删除缓存。
 type httpGetter struct {
-	baseURL string
+	baseURL  string
+	client   *http.Client
+	identity string // 不为空时使用 secret 对每个请求签名
+	secret   []byte
+}
+
+// url 根据 group 和 key 构建请求的 URL，形如 baseURL/group/key。
+func (h *httpGetter) url(group string, key string) string {
+	return fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.PathEscape(group),
+		url.PathEscape(key),
+	)
 }
 
 // Get 通过HTTP GET请求从指定的URL获取资源。
 // group和key用于构建请求的URL路径。
 // 返回获取到的字节数据和可能发生的错误。
 func (h *httpGetter) Get(group string, key string) ([]byte, error) {
-	u := fmt.Sprintf(
-		"%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(group),
-		url.QueryEscape(key),
-	)
-	res, err := http.Get(u)
+	req, err := http.NewRequest(http.MethodGet, h.url(group, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.send(req)
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +66,75 @@ func (h *httpGetter) Get(group string, key string) ([]byte, error) {
 
 	return bytes, nil
 }
-func (h *httpGetter) Insert(group string, key string, value []byte) {
-	panic("(h *httpGetter) Insert todo")
+
+// Insert 通过 HTTP PUT 请求把 value 写入远程节点，请求体即为 value。
+func (h *httpGetter) Insert(group string, key string, value []byte) error {
+	req, err := http.NewRequest(http.MethodPut, h.url(group, key), bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return h.do(req)
 }
+
+// Delete 通过 HTTP DELETE 请求删除远程节点上的缓存。
 func (h *httpGetter) Delete(group string, key string) error {
-	panic("(h *httpGetter) Delete todo")
+	req, err := http.NewRequest(http.MethodDelete, h.url(group, key), nil)
+	if err != nil {
+		return err
+	}
+	return h.do(req)
+}
+
+// sign 使用 secret 为请求签名，签名写入请求头。
+func (h *httpGetter) sign(req *http.Request) {
+	opt := &Option{}
+	SignOption(opt, h.identity, h.secret)
+	req.Header.Set(httpIdentityHeader, opt.Identity)
+	req.Header.Set(httpTimestampHeader, strconv.FormatInt(opt.Timestamp, 10))
+	req.Header.Set(httpNonceHeader, opt.Nonce)
+	req.Header.Set(httpTokenHeader, opt.Token)
+}
+
+// send 在设置了身份时为请求签名，然后发送请求。
+func (h *httpGetter) send(req *http.Request) (*http.Response, error) {
+	if h.identity != "" {
+		h.sign(req)
+	}
+	return h.client.Do(req)
+}
+
+// do 发送请求，并把非 2xx 的响应转换为错误。
+func (h *httpGetter) do(req *http.Request) error {
+	res, err := h.send(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("server returned: %v: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
 }
 
 var _ PeerGetter = (*httpGetter)(nil)
 
+// 节点之间 HTTP 请求的认证信息放在请求头中，字段与握手时 Option 中的同名字段含义相同。
+const (
+	httpIdentityHeader  = "X-Cache-Identity"
+	httpTimestampHeader = "X-Cache-Timestamp"
+	httpNonceHeader     = "X-Cache-Nonce"
+	httpTokenHeader     = "X-Cache-Token"
+)
+
+// httpMethods 把 HTTP 方法映射为 Policy 判断时使用的方法名，与自定义 RPC 的方法名相同。
+var httpMethods = map[string]string{
+	http.MethodGet:    "Group.Get",
+	http.MethodPut:    "Group.Insert",
+	http.MethodDelete: "Group.Delete",
+}
+
 // self，用来记录自己的地址，包括主机名/IP 和端口。
 // basePath，作为节点间通讯地址的前缀，默认是 /_geecache/，
 // 那么 http://example.com/_geecache/ 开头的请求，就用于节点间的访问。因为一个主机上还可能承载其他的服务，
@@ -67,14 +143,75 @@ type HTTPPool struct {
 	self        string
 	basePath    string
 	mu          sync.Mutex
-	peers       *consistenthash.Map    // 用来根据具体的 key 选择节点
-	httpGetters map[string]*httpGetter // 映射远程节点与对应的 httpGetter。每一个远程节点对应一个 httpGetter，因为 httpGetter 与远程节点的地址 baseURL 有关。
+	placement   string                   // 选择节点的算法，见 consistenthash.NewPlacement
+	peers       consistenthash.Placement // 用来根据具体的 key 选择节点
+	httpGetters map[string]*httpGetter   // 映射远程节点与对应的 httpGetter。每一个远程节点对应一个 httpGetter，因为 httpGetter 与远程节点的地址 baseURL 有关。
+	client      *http.Client             // httpGetter 访问远程节点时使用的 HTTP 客户端
+	replicator  *Replicator              // 不为空时把写操作转发到副本节点
+	auth        Authenticator            // 不为空时校验每个请求的签名
+	policy      Policy                   // 不为空时判断请求的身份能否调用对应的方法
+	identity    string                   // 不为空时 httpGetter 使用 secret 对请求签名
+	secret      []byte
 }
 
 func NewHTTPPool(self string) *HTTPPool {
 	return &HTTPPool{
 		self:     self,
 		basePath: defaultBasePath,
+		client:   http.DefaultClient,
+	}
+}
+
+// SetReplicator 设置转发写操作使用的 Replicator，需要在开始处理请求之前调用。
+// Replicator 通过节点 RPC 转发，副本节点需要同时运行 Server。
+func (p *HTTPPool) SetReplicator(r *Replicator) {
+	p.replicator = r
+}
+
+// SetAuth 设置校验请求使用的认证方式和授权策略，需要在开始处理请求之前调用。
+// 请求头中的签名由 SetSigner 生成，认证失败返回 401，没有权限返回 403。
+// 未设置时任何能访问 basePath 的客户端都可以读写和删除缓存。
+func (p *HTTPPool) SetAuth(auth Authenticator, policy Policy) {
+	p.auth = auth
+	p.policy = policy
+}
+
+// SetSigner 设置访问远程节点时使用的 HMAC 身份和密钥，配合远程节点的 SetAuth(&HMACAuthenticator{...}) 使用。
+// 每个请求都会重新签名。需要在 Set() 之前调用。
+func (p *HTTPPool) SetSigner(identity string, secret []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.identity = identity
+	p.secret = secret
+}
+
+// authorize 校验请求头中的签名，并检查认证得到的身份能否在 group 上调用 method。
+func (p *HTTPPool) authorize(r *http.Request, method, group string) (int, error) {
+	identity := ""
+	if p.auth != nil {
+		ts, _ := strconv.ParseInt(r.Header.Get(httpTimestampHeader), 10, 64)
+		opt := &Option{Identity: r.Header.Get(httpIdentityHeader), Timestamp: ts,
+			Nonce: r.Header.Get(httpNonceHeader), Token: r.Header.Get(httpTokenHeader)}
+		id, err := p.auth.Authenticate(opt)
+		if err != nil {
+			return http.StatusUnauthorized, err
+		}
+		identity = id
+	}
+	if p.policy != nil && !p.policy.Allow(identity, method, group) {
+		return http.StatusForbidden, fmt.Errorf("%w: %q cannot call %s on group %s", ErrPermissionDenied, identity, method, group)
+	}
+	return 0, nil
+}
+
+// SetTLSConfig 设置访问远程节点时使用的 TLS 配置，此时节点地址应使用 https:// 前缀。
+// config 中设置了 Certificates 时会向远程节点出示客户端证书（mTLS），可由 NewClientTLSConfig 创建。
+// 需要在 Set() 之前调用。
+func (p *HTTPPool) SetTLSConfig(config *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: config},
 	}
 }
 
@@ -86,8 +223,9 @@ func (p *HTTPPool) Log(format string, v ...interface{}) {
 // ServerHTTP 处理所有的HTTP请求。
 // 它首先检查请求的URL路径是否以basePath开头，如果不是，则抛出异常。
 // 接着，它解析URL路径，提取出group名称和key。
-// 然后，它尝试获取对应的group和view，如果不存在，则返回相应的错误。
-// 最后，它设置响应的Content-Type并写入view的数据。
+// 然后，它尝试获取对应的group，如果不存在，则返回相应的错误。
+// 设置了 SetAuth 时校验请求的签名和权限。
+// 最后，根据请求方法处理：GET 设置响应的Content-Type并写入view的数据，PUT 把请求体写入缓存，DELETE 删除缓存。
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
@@ -110,43 +248,122 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	method, ok := httpMethods[r.Method]
+	if !ok {
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if code, err := p.authorize(r, method, groupName); err != nil {
+		p.Log("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), code)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(view.ByteSlice())
+	switch r.Method {
+	case http.MethodGet:
+		view, err := group.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setVersion(w, view.Version())
+		w.Write(view.ByteSlice())
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		stored := group.insert(key, ByteView{b: value})
+		if err := p.replicator.put(group, key, stored); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		dead, err := group.delete(key)
+		// 本地不存在时副本上仍然可能存在，同样需要把删除标记复制到副本
+		if rerr := p.replicator.put(group, key, dead); rerr != nil {
+			http.Error(w, rerr.Error(), http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
 
-// Set() 方法实例化了一致性哈希算法，并且添加了传入的节点。
-// 并为每一个节点创建了一个 HTTP 客户端 httpGetter。
-func (p *HTTPPool) Set(peer string) {
+// SetPlacement 设置选择节点的算法：ring（默认）、rendezvous、jump 或 maglev，需要在 Set() 之前调用。
+func (p *HTTPPool) SetPlacement(kind string) error {
+	if _, err := consistenthash.NewPlacement(kind, defaultReplice, nil); err != nil {
+		return err
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.placement = kind
+	return nil
+}
 
-	p.peers = consistenthash.New(defaultReplice, nil)
-	p.peers.Add(peer)
-	p.httpGetters = make(map[string]*httpGetter, len(peer))
+// Set() 方法实例化了一致性哈希算法，并且添加了传入的所有节点（包括自己），节点地址形如 http://example.com:8001。
+// 并为每一个节点创建了一个 HTTP 客户端 httpGetter。
+func (p *HTTPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	p.httpGetters[peer] = &httpGetter{
-		baseURL: peer + p.basePath,
+	p.peers, _ = consistenthash.NewPlacement(p.placement, defaultReplice, nil)
+	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.peers.Add(peers...)
+	for _, peer := range peers {
+		p.httpGetters[peer] = &httpGetter{
+			baseURL:  peer + p.basePath,
+			client:   p.client,
+			identity: p.identity,
+			secret:   p.secret,
+		}
 	}
-
 }
 
-// PickerPeer() 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点对应的 HTTP 客户端。
+// PickerPeer() 包装了一致性哈希算法的 Get() 方法，根据具体的 key，选择节点，返回节点的地址。
+// key 属于自己或者还没有设置节点时返回空字符串，表示应该在本地处理。
 func (p *HTTPPool) PickPeer(key string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return ""
+	}
+	//根据key找到对应的真实节点
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		p.Log("Pick peer %s", peer)
+		return peer
+	}
 	return ""
-	// p.mu.Lock()
-	// defer p.mu.Unlock()
-	// //根据key找到对应的真实节点
-	// if peer := p.peers.Get(key); peer != "" && peer != p.self {
-	// 	p.Log("Pick peer %s", peer)
-	// 	return p.httpGetters[peer], true
-	// }
-	// return nil, false
+}
+
+// PickPeers 返回 key 的 n 个副本节点，第一个是主节点，列表中可能包含自己。
+func (p *HTTPPool) PickPeers(key string, n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	return p.peers.GetN(key, n)
+}
+
+// Getter 返回访问节点 peer 的 HTTP 客户端，通常与 PickPeer 配合使用。
+func (p *HTTPPool) Getter(peer string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	getter, ok := p.httpGetters[peer]
+	return getter, ok
 }
 
 var _ PeerPicker = (*HTTPPool)(nil)
@@ -0,0 +1,196 @@
+package distributecache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// httpNode 是测试中的一个 HTTP 节点，记录收到的请求，用于检查请求是否到达了 key 所属的节点。
+type httpNode struct {
+	pool     *HTTPPool
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []string // "METHOD key"
+}
+
+func (n *httpNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	n.mu.Lock()
+	n.requests = append(n.requests, r.Method+" "+key)
+	n.mu.Unlock()
+	n.pool.ServeHTTP(w, r)
+}
+
+func (n *httpNode) received(req string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, r := range n.requests {
+		if r == req {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHTTPPoolRouting(t *testing.T) {
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	nodes := make(map[string]*httpNode)
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		n := &httpNode{}
+		n.server = httptest.NewServer(n)
+		t.Cleanup(n.server.Close)
+		nodes[n.server.URL] = n
+		addrs = append(addrs, n.server.URL)
+	}
+	for addr, n := range nodes {
+		n.pool = NewHTTPPool(addr)
+		n.pool.Set(addrs...)
+	}
+
+	client := nodes[addrs[0]].pool
+	owners := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner := client.PickPeer(key)
+		if owner == "" {
+			// key 属于发起请求的节点自己
+			owner = addrs[0]
+		}
+		owners[owner]++
+		// 所有节点对 key 的归属看法一致
+		for addr, n := range nodes {
+			if got := n.pool.PickPeer(key); got != owner && !(got == "" && addr == owner) {
+				t.Fatalf("%s: node %s picks %q, node %s picks %s", key, addr, got, addrs[0], owner)
+			}
+		}
+
+		getter, ok := client.Getter(owner)
+		if !ok {
+			t.Fatalf("no getter for %s", owner)
+		}
+		value := "value" + strconv.Itoa(i)
+		if err := getter.Insert(t.Name(), key, []byte(value)); err != nil {
+			t.Fatalf("insert %s: %v", key, err)
+		}
+		if got, err := getter.Get(t.Name(), key); err != nil || string(got) != value {
+			t.Fatalf("get %s = %q, %v", key, got, err)
+		}
+		if err := getter.Delete(t.Name(), key); err != nil {
+			t.Fatalf("delete %s: %v", key, err)
+		}
+		if _, err := getter.Get(t.Name(), key); err == nil {
+			t.Fatalf("get %s after delete succeeded", key)
+		}
+		for _, method := range []string{http.MethodPut, http.MethodGet, http.MethodDelete} {
+			for addr, n := range nodes {
+				if got, want := n.received(method+" "+key), addr == owner; got != want {
+					t.Fatalf("%s %s reached %s: %v, owner is %s", method, key, addr, got, owner)
+				}
+			}
+		}
+	}
+	if len(owners) != len(addrs) {
+		t.Fatalf("keys are owned by %d of %d nodes: %v", len(owners), len(addrs), owners)
+	}
+}
+
+// TestHTTPPoolAuth 检查设置了 SetAuth 的节点拒绝没有签名或者签名错误的请求，只允许策略中的身份写入和删除。
+func TestHTTPPoolAuth(t *testing.T) {
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	g.insert("k", ByteView{b: []byte("v")})
+	secret := []byte("secret")
+	pool := NewHTTPPool("http://node")
+	pool.SetAuth(&HMACAuthenticator{Secret: secret}, ACL{
+		{Identity: "node", Method: "*", Group: "*"},
+		{Identity: "reader", Method: "Group.Get", Group: "*"},
+	})
+	server := httptest.NewServer(pool)
+	defer server.Close()
+	url := server.URL + defaultBasePath + t.Name() + "/k"
+
+	do := func(method string, sign func(*http.Request)) int {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader("evil"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sign != nil {
+			sign(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	signer := func(identity string, secret []byte) func(*http.Request) {
+		return (&httpGetter{identity: identity, secret: secret}).sign
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if code := do(method, nil); code != http.StatusUnauthorized {
+			t.Errorf("unsigned %s = %d, want 401", method, code)
+		}
+		if code := do(method, signer("node", []byte("wrong"))); code != http.StatusUnauthorized {
+			t.Errorf("%s with a bad signature = %d, want 401", method, code)
+		}
+	}
+	if code := do(http.MethodGet, signer("reader", secret)); code != http.StatusOK {
+		t.Errorf("GET by reader = %d, want 200", code)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if code := do(method, signer("reader", secret)); code != http.StatusForbidden {
+			t.Errorf("%s by reader = %d, want 403", method, code)
+		}
+	}
+	if v, ok := g.lookup("k"); !ok || v.String() != "v" {
+		t.Fatalf("rejected requests changed the value: %q, %v", v, ok)
+	}
+
+	// httpGetter 使用 SetSigner 设置的身份对请求签名
+	client := NewHTTPPool("http://client")
+	client.SetSigner("node", secret)
+	client.Set(server.URL)
+	getter, _ := client.Getter(server.URL)
+	if err := getter.Insert(t.Name(), "k", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getter.Get(t.Name(), "k"); err != nil || string(got) != "new" {
+		t.Fatalf("Get = %q, %v, want new", got, err)
+	}
+	if err := getter.Delete(t.Name(), "k"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestHTTPPoolNotFound 检查 key 不存在时 GET 返回 404，数据源的其他错误返回 500。
+func TestHTTPPoolNotFound(t *testing.T) {
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		if key == "broken" {
+			return nil, errors.New("data source is down")
+		}
+		return nil, ErrNotFound
+	}))
+	server := httptest.NewServer(NewHTTPPool("http://node"))
+	defer server.Close()
+	for key, want := range map[string]int{"missing": http.StatusNotFound, "broken": http.StatusInternalServerError} {
+		resp, err := http.Get(server.URL + defaultBasePath + t.Name() + "/" + key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package distributecache
+
+import (
+	"DistributeCache/codec"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 半开的 TCP 连接不会产生任何读写错误，接收协程会一直阻塞在读取上。
+// keepalive 在连接空闲时发送 Header{Ping: true} 探测帧，对端收到后回复 Header{Pong: true}，
+// Body 都是发送探测帧时的时间戳。发送探测帧之后超过 timeout 没有收到对端的任何数据，就认为对端失联并关闭连接。
+
+var ErrKeepaliveTimeout = errors.New("rpc: keepalive timeout, peer is unreachable")
+
+// keepalive 每隔 interval 检查一次连接，直到 done 被关闭。
+// lastRecv 是最近一次收到对端数据的时间（UnixNano），ping 发送探测帧，fail 在对端失联时调用。
+func keepalive(interval, timeout time.Duration, lastRecv *atomic.Int64, done <-chan struct{}, ping func() error, fail func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if time.Since(time.Unix(0, lastRecv.Load())) < interval {
+			continue
+		}
+		sent := time.Now()
+		// 对端失联时写操作可能一直阻塞，因此在新的协程中发送探测帧
+		go func() {
+			if err := ping(); err != nil {
+				log.Println("rpc: keepalive ping error:", err)
+			}
+		}()
+		select {
+		case <-done:
+			return
+		case <-time.After(timeout):
+		}
+		if lastRecv.Load() < sent.UnixNano() {
+			fail()
+			return
+		}
+	}
+}
+
+// sendControl 发送 keepalive 探测帧或回复，以及流式响应的确认帧，body 是时间戳或者确认的帧数。
+func (client *Client) sendControl(h codec.Header, body int64) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return client.cc.Write(&h, body)
+}
+
+// startKeepalive 在 opt.KeepaliveInterval 大于 0 时开启客户端的 keepalive。
+func (client *Client) startKeepalive() {
+	interval, timeout := client.opt.KeepaliveInterval, client.opt.KeepaliveTimeout
+	if interval <= 0 {
+		return
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	go keepalive(interval, timeout, &client.lastRecv, client.done, func() error {
+		return client.sendControl(codec.Header{Ping: true}, time.Now().UnixNano())
+	}, func() {
+		log.Println("rpc client: keepalive timeout, closing connection")
+		client.mu.Lock()
+		client.closeErr = ErrKeepaliveTimeout
+		client.mu.Unlock()
+		// 关闭连接后接收协程会退出，并以 ErrKeepaliveTimeout 结束所有未完成的调用
+		_ = client.cc.Close()
+	})
+}
+
+// SetKeepalive 设置服务端的 keepalive，interval 为 0 时关闭，timeout 为 0 时与 interval 相同。
+// 需要在 Accept 之前调用。客户端无论是否开启 keepalive 都会回复服务端的探测帧。
+func (server *Server) SetKeepalive(interval, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	server.keepaliveInterval = interval
+	server.keepaliveTimeout = timeout
+}
+
+// startKeepalive 为一个连接开启服务端的 keepalive，对端失联时关闭连接，ServeCodec 的读取循环随之退出。
+func (server *Server) startKeepalive(cc codec.Codec, lastRecv *atomic.Int64, done <-chan struct{}, sending *sync.Mutex) {
+	if server.keepaliveInterval <= 0 {
+		return
+	}
+	go keepalive(server.keepaliveInterval, server.keepaliveTimeout, lastRecv, done, func() error {
+		sending.Lock()
+		defer sending.Unlock()
+		return cc.Write(&codec.Header{Ping: true}, time.Now().UnixNano())
+	}, func() {
+		log.Println("rpc server: keepalive timeout, closing connection")
+		_ = cc.Close()
+	})
+}
@@ -0,0 +1,110 @@
+package distributecache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestClientKeepaliveTimeout 检查对端不再回复探测帧时，客户端以 ErrKeepaliveTimeout 结束未完成的调用并关闭连接。
+func TestClientKeepaliveTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	// 对端读取所有数据但从不回复，连接关闭后 closed 被关闭
+	closed := make(chan struct{})
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType,
+		KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond}
+	client, err := NewClient(conn, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	key, reply := "key", ""
+	call := client.Go("Group.Get", &key, &reply, nil)
+	select {
+	case <-call.Done:
+		if !errors.Is(call.Error, ErrKeepaliveTimeout) {
+			t.Fatalf("pending call failed with %v, want ErrKeepaliveTimeout", call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending call did not fail after the peer stopped answering pings")
+	}
+	if client.IsAvailable() {
+		t.Error("client is still available after a keepalive timeout")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed after a keepalive timeout")
+	}
+}
+
+// TestServerKeepaliveTimeout 检查服务端关闭不回复探测帧的空闲连接，而回复探测帧的客户端保持连接。
+func TestServerKeepaliveTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	server := NewServer(g, t.Name(), "tcp@"+lis.Addr().String())
+	server.SetKeepalive(20*time.Millisecond, 50*time.Millisecond)
+	go server.Accept(lis)
+
+	// 只完成握手，之后不再发送任何数据
+	idle, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	if err := json.NewEncoder(idle).Encode(&Option{MagicNumber: MagicNumber, CodecType: DefaultOption.CodecType}); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, idle)
+		closed <- err
+	}()
+
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("idle connection failed with %v, want it closed by the server", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not close the idle connection")
+	}
+	key, reply := "key", ""
+	if call := <-client.Go("Group.Get", &key, &reply, nil).Done; call.Error != nil || reply != "value" {
+		t.Fatalf("Group.Get on a client answering pings = %q, %v", reply, call.Error)
+	}
+}
@@ -40,6 +40,14 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 	return
 }
 
+// Peek 返回 key 对应的值，但不更新它在链表中的位置，用于遍历缓存等不算作访问的场景。
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
 // c.ll.Back() 取到队首节点，从链表中删除。
 // delete(c.cache, kv.key)，从字典中 c.cache 删除该节点的映射关系。
 // 更新当前所用的内存 c.nbytes。
@@ -89,6 +97,22 @@ func (c *Cache) Delete(key string) error {
 	}
 	return errors.New("key not found")
 }
+
+// Range 按照从新到旧的顺序遍历缓存中的所有条目，fn 返回 false 时停止遍历。
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Bytes 返回当前已经使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
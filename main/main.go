@@ -5,6 +5,7 @@ import (
 	"DistributeCache/codec"
 	consistenthash "DistributeCache/consistentHash"
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
@@ -13,12 +14,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var db = map[string]string{
@@ -28,15 +33,108 @@ var db = map[string]string{
 
 const (
 	userPath          = "/_geerpc_/users"
+	registryPath      = "/_geerpc_/registry"
+	apiPath           = "/v1/"
 	baseAddr          = "0.0.0.0:9999"
 	etcdEndpoints     = "http://localhost:2379"
 	leaseTTL          = 60
 	defaultRPCReplice = 10
+	groupName         = "ljc"
 )
 
-// 注册中心服务器
-func startAPIServer(wg *sync.WaitGroup) {
-	peers := consistenthash.New(defaultRPCReplice, nil)
+// newDiscovery 根据 kind 创建服务发现：etcd、http（内置的 HTTP 注册中心，target 是逗号分隔的主、备注册中心地址）、
+// static（target 是逗号分隔的节点地址）或 file（target 是成员列表文件）
+func newDiscovery(kind, target string) distributecache.Discovery {
+	switch kind {
+	case "etcd":
+		if target == "" {
+			target = etcdEndpoints
+		}
+		etcdClient, err := clientv3.New(clientv3.Config{
+			Endpoints:   strings.Split(target, ","),
+			DialTimeout: time.Second,
+		})
+		if err != nil {
+			log.Fatalf("failed to create etcd client: %v", err)
+		}
+		return distributecache.NewEtcdDiscovery(etcdClient, leaseTTL)
+	case "http":
+		if target == "" {
+			target = "http://" + baseAddr + registryPath
+		}
+		return distributecache.NewHTTPDiscovery(target)
+	case "static":
+		return distributecache.NewStaticDiscovery(strings.Split(target, ",")...)
+	case "file":
+		return distributecache.NewFileDiscovery(target)
+	}
+	log.Fatalf("unknown discovery %q, use etcd, http, static or file", kind)
+	return nil
+}
+
+// clusterConfig 是 API 服务器、内置 HTTP 注册中心和副本相关的配置
+type clusterConfig struct {
+	addr     string // API 服务器和注册中心的监听地址
+	snapshot string // 成员列表快照文件，为空表示不持久化
+	primary  string // 主注册中心的地址，不为空时作为它的备用注册中心
+
+	placement  string  // 选择节点的算法，见 consistenthash.NewPlacement
+	loadFactor float64 // 大于 0 时哈希环开启有界负载模式
+
+	replicas    int    // 每个 key 的副本数，包括主节点
+	replication string // 副本的写入方式：sync 或 async
+
+	nodeID int64 // 版本号中的节点 ID，小于 0 时由监听地址得到
+}
+
+// setClockNode 设置本进程分配版本号使用的节点 ID：指定了 cc.nodeID 时使用它，否则由监听地址 host:port 得到，
+// 监听所有地址时使用主机名代替 IP，避免所有节点都使用 0.0.0.0 得到相同的 ID
+func setClockNode(cc clusterConfig, host, port string) {
+	if cc.nodeID >= 0 {
+		if err := distributecache.SetClockNodeID(cc.nodeID); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if name, err := os.Hostname(); err == nil {
+			host = name
+		}
+	}
+	distributecache.SetClockNode(host, port)
+}
+
+// 注册中心服务器，同时在 /v1/ 下提供 REST 接口，通过 opt 连接各个节点转发请求
+func startAPIServer(opt *distributecache.Option, secret string, d distributecache.Discovery, cc clusterConfig, wg *sync.WaitGroup) {
+	peers, err := consistenthash.NewPlacement(cc.placement, defaultRPCReplice, nil)
+	if err != nil {
+		log.Fatalf("API server: %v", err)
+	}
+
+	// quorum 写入和删除的版本号由 API 服务器分配，与节点一样需要自己的节点 ID
+	host, port, err := net.SplitHostPort(cc.addr)
+	if err != nil {
+		log.Fatalf("API server: %v", err)
+	}
+	setClockNode(cc, host, port)
+
+	clients := distributecache.NewClientPool(opt)
+	if secret != "" {
+		clients.SetSigner("api", []byte(secret))
+	}
+	picker := distributecache.NewPlacementPicker(peers)
+	api := distributecache.NewAPIServer(picker, clients)
+	api.SetReplicas(groupName, cc.replicas)
+	if cc.loadFactor > 0 {
+		// 只有哈希环支持有界负载
+		ring, ok := peers.(*consistenthash.Map)
+		if !ok {
+			log.Fatalf("API server: bounded load requires the ring placement, got %q", cc.placement)
+		}
+		ring.SetLoadFactor(cc.loadFactor)
+		api.SetLoadReporter(ring)
+	}
+	http.Handle(apiPath, api)
 
 	http.HandleFunc(userPath, func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
@@ -53,33 +151,108 @@ func startAPIServer(wg *sync.WaitGroup) {
 		w.Write([]byte(rpcAddr))
 	})
 
-	dEndpoints := []string{etcdEndpoints}
-	// 初始化 Etcd 客户端
-	etcdClient, err := clientv3.New(clientv3.Config{
-		Endpoints:   dEndpoints,
-		DialTimeout: time.Second,
-	})
-	if err != nil {
-		log.Fatalf("failed to create etcd client: %v", err)
+	// 内置的 HTTP 注册中心，供使用 http 服务发现的节点注册
+	registry := distributecache.NewRPCRegistery()
+	if err := registry.SetPlacement(cc.placement); err != nil {
+		log.Fatalf("rpc registry: %v", err)
 	}
-	go distributecache.WatchServers(etcdClient, peers)
+	if cc.snapshot != "" {
+		if err := registry.Persist(cc.snapshot); err != nil {
+			log.Fatalf("rpc registry: restore snapshot error: %v", err)
+		}
+	}
+	if cc.primary != "" {
+		go registry.Follow(context.Background(), cc.primary)
+	}
+	registry.Cleanup()
+	registry.HandleHTTP(registryPath)
+
+	go func() {
+		for {
+			err := distributecache.SyncPeers(context.Background(), d, peers, clients, api, picker)
+			log.Printf("discovery: watch members error: %v, retrying", err)
+			time.Sleep(time.Second)
+		}
+	}()
 
 	// 启动一个 HTTP 服务器来处理来自用户的请求
-	log.Printf("API server listening on %s", baseAddr)
-	if err := http.ListenAndServe(baseAddr, nil); err != nil {
+	log.Printf("API server listening on %s", cc.addr)
+	if err := http.ListenAndServe(cc.addr, nil); err != nil {
 		log.Fatalf("API server failed: %v", err)
 	}
 	wg.Done()
 }
 
-// 分布式节点服务器
-func startserver(rpcAddr string, wg *sync.WaitGroup) {
-	gee := distributecache.NewGroup("ljc", 2<<10, distributecache.GetterFunc(func(key string) ([]byte, error) {
+// frontends 是节点额外开启的兼容其他缓存协议的监听地址，为空表示不开启
+type frontends struct {
+	redis     string
+	memcached string
+	grpc      string
+}
+
+// listenFrontend 在 addr 上开启监听，tlsConfig 不为空时使用 TLS
+func listenFrontend(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	return l, nil
+}
+
+// serveFrontends 为 gee 开启 frontends 中指定的协议监听，与节点 RPC 服务相同，
+// tlsConfig 不为空时使用 TLS 监听，secret 不为空时要求客户端认证，replicator 不为空时写操作转发到副本节点
+func serveFrontends(gee *distributecache.Group, f frontends, tlsConfig *tls.Config, secret string, replicator *distributecache.Replicator) {
+	if f.redis != "" {
+		l, err := listenFrontend(f.redis, tlsConfig)
+		if err != nil {
+			log.Fatalf("resp server: listen error: %v", err)
+		}
+		resp := distributecache.NewRESPServer(gee.Name())
+		resp.SetPassword(secret)
+		resp.SetReplicator(replicator)
+		go resp.Serve(l)
+	}
+	if f.memcached != "" {
+		l, err := listenFrontend(f.memcached, tlsConfig)
+		if err != nil {
+			log.Fatalf("memcached server: listen error: %v", err)
+		}
+		mc := distributecache.NewMemcachedServer(gee.Name())
+		mc.SetPassword(secret)
+		mc.SetReplicator(replicator)
+		go mc.Serve(l)
+	}
+	if f.grpc != "" {
+		l, err := net.Listen("tcp", f.grpc)
+		if err != nil {
+			log.Fatalf("grpc server: listen error: %v", err)
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		if secret != "" {
+			opts = append(opts, distributecache.GRPCAuth(&distributecache.HMACAuthenticator{Secret: []byte(secret)})...)
+		}
+		s := distributecache.NewGRPCServer(gee.Name(), opts...)
+		s.SetReplicator(replicator)
+		go s.Serve(l)
+	}
+}
+
+// 分布式节点服务器，tlsConfig 不为空时使用 TLS 监听，secret 不为空时要求客户端使用 HMAC 认证，
+// node 是命令行中指定的权重、可用区等节点信息，注册时补充地址、协议等其余信息
+// opt 是节点之间转发副本时使用的客户端配置
+func startserver(rpcAddr string, tlsConfig *tls.Config, opt *distributecache.Option, secret string, f frontends, d distributecache.Discovery, cc clusterConfig, node distributecache.Node, wg *sync.WaitGroup) {
+	gee := distributecache.NewGroup(groupName, 2<<10, distributecache.GetterFunc(func(key string) ([]byte, error) {
 		log.Println("[SlowDB] search key", key)
 		if v, ok := db[key]; ok {
 			return []byte(v), nil
 		}
-		return nil, fmt.Errorf("%s not exist", key)
+		return nil, fmt.Errorf("%s: %w", key, distributecache.ErrNotFound)
 	}))
 
 	l, err := net.Listen("tcp", rpcAddr)
@@ -92,29 +265,97 @@ func startserver(rpcAddr string, wg *sync.WaitGroup) {
 	serverID := distributecache.GenerateID(ip, port)
 	id := strconv.FormatInt(serverID, 10)
 	fmt.Println("serverID:", id)
-
-	dEndpoints := []string{etcdEndpoints}
-	// 初始化 Etcd 客户端
-	etcdClient, err := clientv3.New(clientv3.Config{
-		Endpoints:   dEndpoints,
-		DialTimeout: time.Second,
-	})
-	if err != nil {
-		log.Fatalf("failed to create etcd client: %v", err)
-	}
+	// 缓存值的版本号使用与 serverID 相同的节点 ID
+	setClockNode(cc, ip, port)
 
 	// 启动RPC服务器
 	log.Printf("rpc server: listening on %s", l.Addr())
-	server := distributecache.NewServer(gee, id, "tcp@"+rpcAddr)
+	protocol := "tcp"
+	if tlsConfig != nil {
+		protocol = "tls"
+	}
+	server := distributecache.NewServer(gee, id, protocol+"@"+rpcAddr)
+	if secret != "" {
+		// 只有其他节点（副本）和 API 服务器（quorum 读写）可以按指定的版本号写入
+		policy := &distributecache.PeerPolicy{Peers: []string{"node", "api"}}
+		server.SetAuth(&distributecache.HMACAuthenticator{Secret: []byte(secret)}, policy)
+	}
 
-	// 将服务器注册到 ETCD
-	go distributecache.RegisterServer(etcdClient, *server, leaseTTL)
+	var replicator *distributecache.Replicator
+	if cc.replicas > 1 {
+		replicator = startReplication(gee, server, opt, secret, d, cc)
+	}
 
-	server.Accept(l)
+	serveFrontends(gee, f, tlsConfig, secret, replicator)
+
+	// 将服务器注册到服务发现，etcd 不可用时会一直重试直到注册成功
+	node.Addr = server.Addr
+	node.Protocol = protocol
+	node.Version = distributecache.Version
+	node.Codecs = []string{string(codec.GobType)}
+	node.CapacityBytes = gee.Stats().MaxBytes
+	member := distributecache.Member{ID: server.ID, Node: node}
+	if err := d.Register(context.Background(), member); err != nil {
+		log.Fatalf("failed to register server: %v", err)
+	}
+	go deregisterOnSignal(d, member)
+
+	if tlsConfig != nil {
+		server.AcceptTLS(l, tlsConfig)
+	} else {
+		server.Accept(l)
+	}
 	wg.Done()
 }
 
-func handleUser(key string, value string, operation string, wg *sync.WaitGroup) (string, string) {
+// startReplication 使节点把 gee 的写操作转发到副本节点，节点通过服务发现维护与 API 服务器相同的哈希环，
+// 返回的 Replicator 同时用于各个前端协议的写操作
+func startReplication(gee *distributecache.Group, server *distributecache.Server, opt *distributecache.Option, secret string, d distributecache.Discovery, cc clusterConfig) *distributecache.Replicator {
+	mode := distributecache.ReplicateAsync
+	switch cc.replication {
+	case "sync":
+		mode = distributecache.ReplicateSync
+	case "async":
+	default:
+		log.Fatalf("unknown replication mode %q, use sync or async", cc.replication)
+	}
+	gee.SetReplication(distributecache.Replication{Factor: cc.replicas, Mode: mode})
+
+	peers, err := consistenthash.NewPlacement(cc.placement, defaultRPCReplice, nil)
+	if err != nil {
+		log.Fatalf("rpc server: %v", err)
+	}
+	picker := distributecache.NewPlacementPicker(peers)
+	clients := distributecache.NewClientPool(opt)
+	if secret != "" {
+		clients.SetSigner("node", []byte(secret))
+	}
+	replicator := distributecache.NewReplicator(server.Addr, picker, clients)
+	server.SetReplicator(replicator)
+	go func() {
+		for {
+			err := distributecache.SyncPeers(context.Background(), d, peers, clients, picker)
+			log.Printf("discovery: watch members error: %v, retrying", err)
+			time.Sleep(time.Second)
+		}
+	}()
+	return replicator
+}
+
+// deregisterOnSignal 在收到 SIGINT 或 SIGTERM 时从服务发现中删除节点后退出，使其他节点立即看到该节点下线
+func deregisterOnSignal(d distributecache.Discovery, member distributecache.Member) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Deregister(ctx, member); err != nil {
+		log.Printf("failed to deregister server: %v", err)
+	}
+	os.Exit(0)
+}
+
+func handleUser(key string, value string, operation string, tlsConfig *tls.Config, secret string, wg *sync.WaitGroup) (string, string) {
 	defer wg.Done()
 	fmt.Println("handleUser", key)
 	// 构造请求的 URL
@@ -147,6 +388,10 @@ func handleUser(key string, value string, operation string, wg *sync.WaitGroup)
 		MagicNumber:    distributecache.MagicNumber,
 		CodecType:      codec.GobType,
 		ConnectTimeout: 10 * time.Second,
+		TLSConfig:      tlsConfig,
+	}
+	if secret != "" {
+		distributecache.SignOption(opt, "cli", []byte(secret))
 	}
 
 	client, err := distributecache.XDial(rpcAddr, opt)
@@ -171,34 +416,102 @@ func handleUser(key string, value string, operation string, wg *sync.WaitGroup)
 	}
 	return "", ""
 }
+
+// clientTLSConfig 在指定了 CA 或客户端证书时返回连接节点使用的 TLS 配置
+func clientTLSConfig(caFile, certFile, keyFile string) *tls.Config {
+	if caFile == "" && certFile == "" {
+		return nil
+	}
+	config, err := distributecache.NewClientTLSConfig(caFile, certFile, keyFile)
+	if err != nil {
+		log.Fatalf("rpc client: tls config error: %v", err)
+	}
+	return config
+}
+
+// evaluatePlacements 输出各个选择节点算法的均衡性、成员变化时迁移的比例和查询耗时
+func evaluatePlacements() {
+	for _, nodes := range []int{3, 10, 100} {
+		for _, kind := range consistenthash.Placements {
+			q, err := consistenthash.Evaluate(kind, defaultRPCReplice, nodes, 100000)
+			if err != nil {
+				log.Fatalf("evaluate %s: %v", kind, err)
+			}
+			fmt.Println(q)
+		}
+	}
+}
+
 func main() {
 	var api bool
 	// 解析命令行参数
-	mode := flag.String("mode", "", "Mode of operation: 'server' or 'client'")
+	mode := flag.String("mode", "", "Mode of operation: 'server', 'client' or 'placement'")
 	rpcAddr := flag.String("addr", "localhost:1234", "Address to listen on or connect to")
 	operation := flag.String("operation", "insert", "Insert or Delete or Search")
 	key := flag.String("key", "", "key")
 	value := flag.String("value", "", "value")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	certFile := flag.String("cert", "", "TLS certificate file")
+	keyFile := flag.String("keyfile", "", "TLS private key file")
+	caFile := flag.String("ca", "", "TLS CA file, enables client certificate verification on server")
+	secret := flag.String("secret", "", "Shared secret for HMAC authentication")
+	var f frontends
+	var node distributecache.Node
+	flag.IntVar(&node.Weight, "weight", 1, "Relative weight of this node")
+	flag.StringVar(&node.Zone, "zone", "", "Availability zone of this node")
+	flag.StringVar(&node.Rack, "rack", "", "Rack of this node")
+	flag.StringVar(&f.redis, "redis", "", "Address to serve the Redis protocol on, e.g. localhost:6379")
+	flag.StringVar(&f.memcached, "memcached", "", "Address to serve the memcached protocol on, e.g. localhost:11211")
+	flag.StringVar(&f.grpc, "grpc", "", "Address to serve the gRPC Cache service on, e.g. localhost:50051")
+	discovery := flag.String("discovery", "etcd", "Service discovery: etcd, http, static or file")
+	discoveryTarget := flag.String("discovery-target", "", "etcd endpoints, comma separated registry URLs, comma separated addresses or members file")
+	var cc clusterConfig
+	flag.StringVar(&cc.addr, "api-addr", baseAddr, "Address for the api server and the built-in registry")
+	flag.StringVar(&cc.snapshot, "registry-snapshot", "", "File to persist the built-in registry membership to")
+	flag.StringVar(&cc.primary, "registry-primary", "", "Primary registry URL, runs the built-in registry as its standby")
+	flag.StringVar(&cc.placement, "placement", "ring", "Placement algorithm: "+strings.Join(consistenthash.Placements, ", "))
+	flag.IntVar(&cc.replicas, "replicas", 1, "Number of replicas of each key, including the primary")
+	flag.StringVar(&cc.replication, "replication", "async", "Replication mode: sync or async")
+	flag.Float64Var(&cc.loadFactor, "load-factor", 0, "Bounded load factor for the api server's hash ring, e.g. 0.25, 0 disables it")
+	flag.Int64Var(&cc.nodeID, "node-id", -1, "Unique node ID (0-1023) in version numbers, derived from the listen address if negative")
 	flag.Parse()
 
 	var wg sync.WaitGroup
 
+	// API 服务器转发请求和节点之间转发副本时使用的客户端配置
+	opt := &distributecache.Option{
+		MagicNumber:    distributecache.MagicNumber,
+		CodecType:      codec.GobType,
+		ConnectTimeout: 10 * time.Second,
+		TLSConfig:      clientTLSConfig(*caFile, *certFile, *keyFile),
+	}
 	if api {
 		wg.Add(1)
-		go startAPIServer(&wg)
+		go startAPIServer(opt, *secret, newDiscovery(*discovery, *discoveryTarget), cc, &wg)
 		wg.Wait()
 	}
 
 	wg.Add(1)
 	switch *mode {
 	case "server":
-		startserver(*rpcAddr, &wg)
+		var tlsConfig *tls.Config
+		if *certFile != "" {
+			config, err := distributecache.NewServerTLSConfig(*certFile, *keyFile, *caFile)
+			if err != nil {
+				log.Fatalf("rpc server: tls config error: %v", err)
+			}
+			tlsConfig = config
+		}
+		startserver(*rpcAddr, tlsConfig, opt, *secret, f, newDiscovery(*discovery, *discoveryTarget), cc, node, &wg)
 	case "client":
-		k, value := handleUser(*key, *value, *operation, &wg)
+		tlsConfig := clientTLSConfig(*caFile, *certFile, *keyFile)
+		k, value := handleUser(*key, *value, *operation, tlsConfig, *secret, &wg)
 		println(k, " ", value)
+	case "placement":
+		evaluatePlacements()
+		wg.Done()
 	default:
-		fmt.Println("Invalid mode. Use 'server' or 'client'.")
+		fmt.Println("Invalid mode. Use 'server', 'client' or 'placement'.")
 		os.Exit(1)
 	}
 	wg.Wait()
@@ -0,0 +1,720 @@
+package distributecache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MemcachedServer 是兼容 memcached 文本协议和二进制协议的前端，使现有的 memcached 客户端可以直接访问本地的 Group。
+// 每个连接根据第一个字节判断使用哪种协议：二进制协议的请求总是以 0x80 开头。
+// 支持的命令：get、gets、set、add、replace、cas、delete、touch、stats、version、quit，
+// 二进制协议还支持对应的 quiet 命令和 noop。
+// 设置了密码时连接需要先认证：二进制协议使用 SASL PLAIN，文本协议与 memcached 的 -Y 选项相同，
+// 第一条命令是数据块为 "<username> <password>" 的 set。共享密码不区分用户，用户名不做检查。
+// 需要 TLS 时在 tls.NewListener 返回的监听上调用 Serve。
+type MemcachedServer struct {
+	group      string      // 所有连接访问的 group
+	password   string      // 不为空时要求客户端认证
+	replicator *Replicator // 不为空时把写操作转发到副本节点
+	start      time.Time
+
+	currConns  atomic.Int64
+	totalConns atomic.Int64
+	cmdGet     atomic.Int64
+	cmdSet     atomic.Int64
+	cmdTouch   atomic.Int64
+}
+
+const (
+	mcVersion      = "1.6.0-distributecache"
+	mcMaxKeyLen    = 250
+	mcRelativeMax  = 60 * 60 * 24 * 30 // exptime 不超过 30 天时是相对时间，否则是 Unix 时间戳
+	mcBinaryHeader = 24
+	mcMagicRequest = 0x80
+	mcMagicReply   = 0x81
+)
+
+// mcReplicaError 是同步复制失败时文本协议的响应，此时本地已经写入。
+var mcReplicaError = "SERVER_ERROR " + ErrReplication.Error()
+
+func NewMemcachedServer(group string) *MemcachedServer {
+	return &MemcachedServer{group: group, start: time.Now()}
+}
+
+// SetPassword 设置客户端认证使用的密码，需要在 Serve 之前调用。
+func (s *MemcachedServer) SetPassword(password string) {
+	s.password = password
+}
+
+// SetReplicator 设置转发写操作使用的 Replicator，通常与节点的 Server.SetReplicator 相同，需要在 Serve 之前调用。
+func (s *MemcachedServer) SetReplicator(r *Replicator) {
+	s.replicator = r
+}
+
+// checkPassword 使用固定时间的比较校验密码。
+func (s *MemcachedServer) checkPassword(password []byte) bool {
+	return subtle.ConstantTimeCompare(password, []byte(s.password)) == 1
+}
+
+// Serve 在 lis 上等待连接，并为每个连接开启一个协程处理。
+func (s *MemcachedServer) Serve(lis net.Listener) error {
+	log.Println("memcached server: accept addr:", lis.Addr().String())
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Println("memcached server: accept error:", err)
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn 依次读取并执行命令，直到连接关闭或者客户端发送 quit。
+func (s *MemcachedServer) ServeConn(conn io.ReadWriteCloser) {
+	s.currConns.Add(1)
+	s.totalConns.Add(1)
+	defer func() {
+		s.currConns.Add(-1)
+		_ = conn.Close()
+	}()
+	c := &mcConn{
+		s:      s,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		authed: s.password == "",
+	}
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == mcMagicRequest {
+		c.serveBinary()
+	} else {
+		c.serveText()
+	}
+}
+
+type mcConn struct {
+	s      *MemcachedServer
+	r      *bufio.Reader
+	w      *bufio.Writer
+	authed bool // 没有设置密码时总是为 true
+}
+
+// flush 在没有更多待处理的请求时刷新缓冲区，客户端一次发送多条命令时合并写入。
+func (c *mcConn) flush(force bool) error {
+	if force || c.r.Buffered() == 0 {
+		return c.w.Flush()
+	}
+	return nil
+}
+
+// mcResult 是写入操作的结果。
+type mcResult int
+
+const (
+	mcStored mcResult = iota
+	mcNotStored
+	mcExists
+	mcNotFound
+	mcReplicaFailed // 本地已经写入，但是同步复制到副本失败，参见 ErrReplication
+)
+
+// mcMode 是写入操作的类型。
+type mcMode int
+
+const (
+	mcSet mcMode = iota
+	mcAdd
+	mcReplace
+	mcCAS
+)
+
+// mcExpire 把 memcached 的 exptime 转换为过期时间：0 表示永不过期，
+// 不超过 30 天时是相对当前的秒数，否则是 Unix 时间戳，负数表示立即过期。
+func mcExpire(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return time.Now()
+	case exptime <= mcRelativeMax:
+		return time.Now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
+
+// store 执行 set、add、replace 和 cas，cas 不为 0 时只有缓存值的 cas 与之相同才会写入。
+// 写入成功时同时返回新值的 cas。
+func (s *MemcachedServer) store(g *Group, mode mcMode, key string, value ByteView, cas uint64) (mcResult, uint64) {
+	s.cmdSet.Add(1)
+	result := mcStored
+	value.v = newVersion()
+	stored, _ := g.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		switch {
+		case mode == mcAdd && ok:
+			result = mcNotStored
+		case mode == mcReplace && !ok:
+			result = mcNotStored
+		case (mode == mcCAS || cas != 0) && !ok:
+			result = mcNotFound
+		case (mode == mcCAS || cas != 0) && old.cas != cas:
+			result = mcExists
+		}
+		return value, result == mcStored
+	})
+	if result == mcStored {
+		result = s.replicated(s.replicator.put(g, key, stored))
+	}
+	return result, stored.cas
+}
+
+// replicated 把转发到副本的结果 err 转换为写入操作的结果。
+func (s *MemcachedServer) replicated(err error) mcResult {
+	if err != nil {
+		log.Println("memcached server:", err)
+		return mcReplicaFailed
+	}
+	return mcStored
+}
+
+// touch 修改 key 的过期时间，key 不存在时返回 mcNotFound。
+// 过期时间随缓存值一起复制，修改后分配新的版本号，使其他节点上的旧值被覆盖。
+func (s *MemcachedServer) touch(g *Group, key string, exptime int64) mcResult {
+	s.cmdTouch.Add(1)
+	version := newVersion()
+	stored, ok := g.update(key, func(old ByteView, ok bool) (ByteView, bool) {
+		old.e = mcExpire(exptime)
+		old.v = version
+		return old, ok
+	})
+	if !ok {
+		return mcNotFound
+	}
+	return s.replicated(s.replicator.put(g, key, stored))
+}
+
+// delete 删除 key，key 不存在时返回 mcNotFound。本地不存在时副本上仍然可能存在，同样需要把删除标记复制到副本。
+func (s *MemcachedServer) delete(g *Group, key string) mcResult {
+	dead, err := g.delete(key)
+	if result := s.replicated(s.replicator.put(g, key, dead)); result != mcStored {
+		return result
+	}
+	if err != nil {
+		return mcNotFound
+	}
+	return mcStored
+}
+
+// get 通过 Group.Get 获取 key 的值，key 不存在时返回 false。
+func (s *MemcachedServer) get(g *Group, key string) (ByteView, bool) {
+	s.cmdGet.Add(1)
+	view, err := g.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Println("memcached server: get error:", err)
+		}
+		return ByteView{}, false
+	}
+	return view, true
+}
+
+// stats 返回 stats 命令输出的统计信息。
+func (s *MemcachedServer) stats(g *Group) [][2]string {
+	st := g.Stats()
+	now := time.Now()
+	return [][2]string{
+		{"pid", strconv.Itoa(os.Getpid())},
+		{"uptime", strconv.FormatInt(int64(now.Sub(s.start)/time.Second), 10)},
+		{"time", strconv.FormatInt(now.Unix(), 10)},
+		{"version", mcVersion},
+		{"curr_connections", strconv.FormatInt(s.currConns.Load(), 10)},
+		{"total_connections", strconv.FormatInt(s.totalConns.Load(), 10)},
+		{"cmd_get", strconv.FormatInt(s.cmdGet.Load(), 10)},
+		{"cmd_set", strconv.FormatInt(s.cmdSet.Load(), 10)},
+		{"cmd_touch", strconv.FormatInt(s.cmdTouch.Load(), 10)},
+		{"get_hits", strconv.FormatInt(st.Hits, 10)},
+		{"get_misses", strconv.FormatInt(st.Misses, 10)},
+		{"curr_items", strconv.Itoa(st.Keys)},
+		{"bytes", strconv.FormatInt(st.Bytes, 10)},
+		{"limit_maxbytes", strconv.FormatInt(st.MaxBytes, 10)},
+	}
+}
+
+func validKey(key string) bool {
+	if key == "" || len(key) > mcMaxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// serveText 处理文本协议，每条命令占一行，存储命令之后紧跟一个数据块。
+func (c *mcConn) serveText() {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Println("memcached server: read command error:", err)
+			}
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+			if c.flush(false) != nil {
+				return
+			}
+			continue
+		}
+		quit, err := c.executeText(args)
+		if ferr := c.flush(quit || err != nil); ferr != nil || quit || err != nil {
+			return
+		}
+	}
+}
+
+// executeText 执行一条文本命令，返回 true 表示关闭连接，返回错误表示无法继续解析后续的数据。
+func (c *mcConn) executeText(args []string) (bool, error) {
+	g := GetGroup(c.s.group)
+	if g == nil {
+		c.w.WriteString("SERVER_ERROR no such group " + c.s.group + "\r\n")
+		return false, nil
+	}
+	if !c.authed {
+		switch args[0] {
+		case "quit":
+			return true, nil
+		case "set":
+			return false, c.authText(args)
+		}
+		c.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+		return false, nil
+	}
+	switch args[0] {
+	case "get", "gets":
+		if len(args) < 2 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		for _, key := range args[1:] {
+			view, ok := c.s.get(g, key)
+			if !ok {
+				continue
+			}
+			if args[0] == "gets" {
+				fmt.Fprintf(c.w, "VALUE %s %d %d %d\r\n", key, view.f, view.Len(), view.cas)
+			} else {
+				fmt.Fprintf(c.w, "VALUE %s %d %d\r\n", key, view.f, view.Len())
+			}
+			c.w.Write(view.b)
+			c.w.WriteString("\r\n")
+		}
+		c.w.WriteString("END\r\n")
+	case "set", "add", "replace", "cas":
+		return false, c.storeText(g, args)
+	case "delete":
+		// 旧版本的客户端会在 key 之后附带一个为 0 的时间参数
+		if len(args) < 2 || len(args) > 4 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		noreply := args[len(args)-1] == "noreply"
+		switch c.s.delete(g, args[1]) {
+		case mcStored:
+			c.reply(noreply, "DELETED")
+		case mcNotFound:
+			c.reply(noreply, "NOT_FOUND")
+		default:
+			c.reply(noreply, mcReplicaError)
+		}
+	case "touch":
+		if len(args) != 3 && len(args) != 4 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		exptime, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			c.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+			return false, nil
+		}
+		noreply := len(args) == 4 && args[3] == "noreply"
+		switch c.s.touch(g, args[1], exptime) {
+		case mcStored:
+			c.reply(noreply, "TOUCHED")
+		case mcNotFound:
+			c.reply(noreply, "NOT_FOUND")
+		default:
+			c.reply(noreply, mcReplicaError)
+		}
+	case "stats":
+		if len(args) > 1 {
+			// 不支持 stats items、stats slabs 等子命令，返回空的统计信息
+			c.w.WriteString("END\r\n")
+			return false, nil
+		}
+		for _, kv := range c.s.stats(g) {
+			c.w.WriteString("STAT " + kv[0] + " " + kv[1] + "\r\n")
+		}
+		c.w.WriteString("END\r\n")
+	case "version":
+		c.w.WriteString("VERSION " + mcVersion + "\r\n")
+	case "quit":
+		return true, nil
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return false, nil
+}
+
+func (c *mcConn) reply(noreply bool, msg string) {
+	if !noreply {
+		c.w.WriteString(msg + "\r\n")
+	}
+}
+
+// authText 执行认证用的 set <key> <flags> <exptime> <bytes>，数据块是 "<username> <password>"。
+// 与 memcached 相同，认证失败时关闭连接。
+func (c *mcConn) authText(args []string) error {
+	if len(args) != 5 && len(args) != 6 {
+		c.w.WriteString("ERROR\r\n")
+		return errors.New("memcached server: bad command line format")
+	}
+	size, err := strconv.Atoi(args[4])
+	if err != nil || size < 0 || size > maxValueBytes {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return errors.New("memcached server: bad command line format")
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	_, password, ok := bytes.Cut(bytes.TrimSuffix(data, []byte("\r\n")), []byte(" "))
+	if !ok || !c.s.checkPassword(password) {
+		c.w.WriteString("CLIENT_ERROR authentication failure\r\n")
+		return errors.New("memcached server: authentication failure")
+	}
+	c.authed = true
+	c.w.WriteString("STORED\r\n")
+	return nil
+}
+
+// storeText 执行 <command> <key> <flags> <exptime> <bytes> [cas unique] [noreply]，并读取之后的数据块。
+// 命令格式错误时无法确定数据块的长度，返回错误关闭连接。
+func (c *mcConn) storeText(g *Group, args []string) error {
+	mode := map[string]mcMode{"set": mcSet, "add": mcAdd, "replace": mcReplace, "cas": mcCAS}[args[0]]
+	n := 5
+	if mode == mcCAS {
+		n = 6
+	}
+	if len(args) != n && len(args) != n+1 {
+		c.w.WriteString("ERROR\r\n")
+		return errors.New("memcached server: bad command line format")
+	}
+	key := args[1]
+	flags, err1 := strconv.ParseUint(args[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[3], 10, 64)
+	size, err3 := strconv.Atoi(args[4])
+	var cas uint64
+	var err4 error
+	if mode == mcCAS {
+		cas, err4 = strconv.ParseUint(args[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 || size > maxValueBytes {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return errors.New("memcached server: bad command line format")
+	}
+	noreply := len(args) == n+1 && args[n] == "noreply"
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return errors.New("memcached server: bad data chunk")
+	}
+	if !validKey(key) {
+		c.w.WriteString("CLIENT_ERROR bad key\r\n")
+		return nil
+	}
+
+	value := ByteView{b: data[:size], e: mcExpire(exptime), f: uint32(flags)}
+	result, _ := c.s.store(g, mode, key, value, cas)
+	switch result {
+	case mcStored:
+		c.reply(noreply, "STORED")
+	case mcNotStored:
+		c.reply(noreply, "NOT_STORED")
+	case mcExists:
+		c.reply(noreply, "EXISTS")
+	case mcNotFound:
+		c.reply(noreply, "NOT_FOUND")
+	case mcReplicaFailed:
+		c.reply(noreply, mcReplicaError)
+	}
+	return nil
+}
+
+// 二进制协议的命令和状态码
+const (
+	mcOpGet      = 0x00
+	mcOpSet      = 0x01
+	mcOpAdd      = 0x02
+	mcOpReplace  = 0x03
+	mcOpDelete   = 0x04
+	mcOpQuit     = 0x07
+	mcOpGetQ     = 0x09
+	mcOpNoop     = 0x0a
+	mcOpVersion  = 0x0b
+	mcOpGetK     = 0x0c
+	mcOpGetKQ    = 0x0d
+	mcOpStat     = 0x10
+	mcOpSetQ     = 0x11
+	mcOpAddQ     = 0x12
+	mcOpReplaceQ = 0x13
+	mcOpDeleteQ  = 0x14
+	mcOpQuitQ    = 0x17
+	mcOpTouch    = 0x1c
+	mcOpSASLList = 0x20
+	mcOpSASLAuth = 0x21
+
+	mcStatusOK          = 0x00
+	mcStatusNotFound    = 0x01
+	mcStatusExists      = 0x02
+	mcStatusTooLarge    = 0x03
+	mcStatusInvalidArgs = 0x04
+	mcStatusNotStored   = 0x05
+	mcStatusAuthError   = 0x20
+	mcStatusUnknown     = 0x81
+	mcStatusInternal    = 0x84
+)
+
+// mcPacket 是二进制协议的一个请求：24 字节的头部之后依次是 extras、key 和 value。
+type mcPacket struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+func (c *mcConn) readPacket() (*mcPacket, error) {
+	var h [mcBinaryHeader]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return nil, err
+	}
+	if h[0] != mcMagicRequest {
+		return nil, fmt.Errorf("invalid magic 0x%02x", h[0])
+	}
+	keyLen := int(binary.BigEndian.Uint16(h[2:4]))
+	extrasLen := int(h[4])
+	bodyLen := int(binary.BigEndian.Uint32(h[8:12]))
+	if bodyLen < keyLen+extrasLen || bodyLen > maxValueBytes+mcMaxKeyLen+extrasLen {
+		return nil, fmt.Errorf("invalid body length %d", bodyLen)
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	return &mcPacket{
+		opcode: h[1],
+		opaque: binary.BigEndian.Uint32(h[12:16]),
+		cas:    binary.BigEndian.Uint64(h[16:24]),
+		extras: body[:extrasLen],
+		key:    string(body[extrasLen : extrasLen+keyLen]),
+		value:  body[extrasLen+keyLen:],
+	}, nil
+}
+
+// writePacket 写入对请求 p 的响应。
+func (c *mcConn) writePacket(p *mcPacket, status uint16, cas uint64, extras []byte, key string, value []byte) {
+	var h [mcBinaryHeader]byte
+	h[0] = mcMagicReply
+	h[1] = p.opcode
+	binary.BigEndian.PutUint16(h[2:4], uint16(len(key)))
+	h[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(h[6:8], status)
+	binary.BigEndian.PutUint32(h[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(h[12:16], p.opaque)
+	binary.BigEndian.PutUint64(h[16:24], cas)
+	c.w.Write(h[:])
+	c.w.Write(extras)
+	c.w.WriteString(key)
+	c.w.Write(value)
+}
+
+// writeStatus 写入只包含状态码的响应，出错时 value 是错误信息。
+func (c *mcConn) writeStatus(p *mcPacket, status uint16, msg string) {
+	c.writePacket(p, status, 0, nil, "", []byte(msg))
+}
+
+// serveBinary 处理二进制协议。
+func (c *mcConn) serveBinary() {
+	for {
+		p, err := c.readPacket()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("memcached server: read packet error:", err)
+			}
+			return
+		}
+		quit := c.executeBinary(p)
+		if ferr := c.flush(quit); ferr != nil || quit {
+			return
+		}
+	}
+}
+
+// executeBinary 执行一个二进制请求，返回 true 表示关闭连接。
+// quiet 命令只在出错时回复，GetQ 和 GetKQ 则只在命中时回复。
+func (c *mcConn) executeBinary(p *mcPacket) bool {
+	g := GetGroup(c.s.group)
+	if g == nil {
+		c.writeStatus(p, mcStatusInternal, "no such group "+c.s.group)
+		return false
+	}
+	switch p.opcode {
+	case mcOpSASLList:
+		c.writePacket(p, mcStatusOK, 0, nil, "", []byte("PLAIN"))
+		return false
+	case mcOpSASLAuth:
+		c.saslAuth(p)
+		return false
+	case mcOpVersion, mcOpQuit, mcOpQuitQ:
+	default:
+		if !c.authed {
+			c.writeStatus(p, mcStatusAuthError, "Auth failure")
+			return false
+		}
+	}
+	switch p.opcode {
+	case mcOpGet, mcOpGetQ, mcOpGetK, mcOpGetKQ:
+		view, ok := c.s.get(g, p.key)
+		if !ok {
+			if p.opcode == mcOpGet || p.opcode == mcOpGetK {
+				c.writeStatus(p, mcStatusNotFound, "Not found")
+			}
+			return false
+		}
+		extras := binary.BigEndian.AppendUint32(nil, view.f)
+		key := ""
+		if p.opcode == mcOpGetK || p.opcode == mcOpGetKQ {
+			key = p.key
+		}
+		c.writePacket(p, mcStatusOK, view.cas, extras, key, view.b)
+	case mcOpSet, mcOpSetQ, mcOpAdd, mcOpAddQ, mcOpReplace, mcOpReplaceQ:
+		if len(p.extras) != 8 || !validKey(p.key) {
+			c.writeStatus(p, mcStatusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		mode := map[byte]mcMode{
+			mcOpSet: mcSet, mcOpSetQ: mcSet,
+			mcOpAdd: mcAdd, mcOpAddQ: mcAdd,
+			mcOpReplace: mcReplace, mcOpReplaceQ: mcReplace,
+		}[p.opcode]
+		value := ByteView{
+			b: p.value,
+			f: binary.BigEndian.Uint32(p.extras[0:4]),
+			e: mcExpire(int64(int32(binary.BigEndian.Uint32(p.extras[4:8])))),
+		}
+		quiet := p.opcode == mcOpSetQ || p.opcode == mcOpAddQ || p.opcode == mcOpReplaceQ
+		result, cas := c.s.store(g, mode, p.key, value, p.cas)
+		switch result {
+		case mcStored:
+			if !quiet {
+				c.writePacket(p, mcStatusOK, cas, nil, "", nil)
+			}
+		case mcNotStored:
+			if mode == mcAdd {
+				c.writeStatus(p, mcStatusExists, "Data exists for key.")
+			} else {
+				c.writeStatus(p, mcStatusNotFound, "Not found")
+			}
+		case mcExists:
+			c.writeStatus(p, mcStatusExists, "Data exists for key.")
+		case mcNotFound:
+			c.writeStatus(p, mcStatusNotFound, "Not found")
+		case mcReplicaFailed:
+			c.writeStatus(p, mcStatusInternal, ErrReplication.Error())
+		}
+	case mcOpDelete, mcOpDeleteQ:
+		switch c.s.delete(g, p.key) {
+		case mcStored:
+			if p.opcode == mcOpDelete {
+				c.writePacket(p, mcStatusOK, 0, nil, "", nil)
+			}
+		case mcNotFound:
+			c.writeStatus(p, mcStatusNotFound, "Not found")
+		default:
+			c.writeStatus(p, mcStatusInternal, ErrReplication.Error())
+		}
+	case mcOpTouch:
+		if len(p.extras) != 4 {
+			c.writeStatus(p, mcStatusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		exptime := int64(int32(binary.BigEndian.Uint32(p.extras)))
+		switch c.s.touch(g, p.key, exptime) {
+		case mcStored:
+			c.writePacket(p, mcStatusOK, 0, nil, "", nil)
+		case mcNotFound:
+			c.writeStatus(p, mcStatusNotFound, "Not found")
+		default:
+			c.writeStatus(p, mcStatusInternal, ErrReplication.Error())
+		}
+	case mcOpStat:
+		// 每一项统计信息是一个响应，最后以 key 和 value 都为空的响应结束
+		if p.key == "" {
+			for _, kv := range c.s.stats(g) {
+				c.writePacket(p, mcStatusOK, 0, nil, kv[0], []byte(kv[1]))
+			}
+		}
+		c.writePacket(p, mcStatusOK, 0, nil, "", nil)
+	case mcOpNoop:
+		c.writePacket(p, mcStatusOK, 0, nil, "", nil)
+	case mcOpVersion:
+		c.writePacket(p, mcStatusOK, 0, nil, "", []byte(mcVersion))
+	case mcOpQuit:
+		c.writePacket(p, mcStatusOK, 0, nil, "", nil)
+		return true
+	case mcOpQuitQ:
+		return true
+	default:
+		c.writeStatus(p, mcStatusUnknown, "Unknown command")
+	}
+	return false
+}
+
+// saslAuth 执行 SASL PLAIN 认证，value 的格式是 "[authzid]\x00username\x00password"。
+func (c *mcConn) saslAuth(p *mcPacket) {
+	if p.key != "PLAIN" {
+		c.writeStatus(p, mcStatusAuthError, "Auth failure")
+		return
+	}
+	parts := bytes.SplitN(p.value, []byte{0}, 3)
+	if c.s.password == "" || len(parts) != 3 || !c.s.checkPassword(parts[2]) {
+		c.writeStatus(p, mcStatusAuthError, "Auth failure")
+		return
+	}
+	c.authed = true
+	c.writePacket(p, mcStatusOK, 0, nil, "", []byte("Authenticated"))
+}
@@ -0,0 +1,134 @@
+package distributecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func dialMemcached(t *testing.T, password string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	s := NewMemcachedServer(t.Name())
+	s.SetPassword(password)
+	return serveMemcached(t, s)
+}
+
+// serveMemcached 在随机端口上启动 s 并建立一个连接。
+func serveMemcached(t *testing.T, s *MemcachedServer) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	go s.Serve(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// mcText 发送一条文本命令，返回回复的第一行。
+func mcText(t *testing.T, conn net.Conn, r *bufio.Reader, cmd string) string {
+	t.Helper()
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		t.Fatal(err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// mcBinary 发送一个二进制请求，返回响应的状态码和 value。
+func mcBinary(t *testing.T, conn net.Conn, r *bufio.Reader, opcode byte, key string, value []byte) (uint16, []byte) {
+	t.Helper()
+	h := make([]byte, mcBinaryHeader)
+	h[0] = mcMagicRequest
+	h[1] = opcode
+	binary.BigEndian.PutUint16(h[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint32(h[8:12], uint32(len(key)+len(value)))
+	if _, err := conn.Write(append(append(h, key...), value...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, h); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(h[8:12]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	skip := int(h[4]) + int(binary.BigEndian.Uint16(h[2:4]))
+	return binary.BigEndian.Uint16(h[6:8]), body[skip:]
+}
+
+func TestMemcachedTextAuth(t *testing.T) {
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	conn, r := dialMemcached(t, "secret")
+	if got := mcText(t, conn, r, "get k\r\n"); got != "CLIENT_ERROR unauthenticated" {
+		t.Fatalf("get before auth = %q", got)
+	}
+	if got := mcText(t, conn, r, "set auth 0 0 11\r\nuser secret\r\n"); got != "STORED" {
+		t.Fatalf("auth = %q", got)
+	}
+	if got := mcText(t, conn, r, "set k 0 0 1\r\nv\r\n"); got != "STORED" {
+		t.Fatalf("set after auth = %q", got)
+	}
+
+	// 认证失败后连接被关闭
+	conn, r = dialMemcached(t, "secret")
+	if got := mcText(t, conn, r, "set auth 0 0 10\r\nuser wrong\r\n"); got != "CLIENT_ERROR authentication failure" {
+		t.Fatalf("wrong password = %q", got)
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Fatalf("connection still open after a failed auth: %v", err)
+	}
+}
+
+func TestMemcachedSASL(t *testing.T) {
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	conn, r := dialMemcached(t, "secret")
+	if status, _ := mcBinary(t, conn, r, mcOpGet, "k", nil); status != mcStatusAuthError {
+		t.Fatalf("get before auth: status 0x%02x", status)
+	}
+	if status, mechs := mcBinary(t, conn, r, mcOpSASLList, "", nil); status != mcStatusOK || string(mechs) != "PLAIN" {
+		t.Fatalf("list mechs = 0x%02x %q", status, mechs)
+	}
+	if status, _ := mcBinary(t, conn, r, mcOpSASLAuth, "PLAIN", []byte("\x00user\x00wrong")); status != mcStatusAuthError {
+		t.Fatalf("wrong password: status 0x%02x", status)
+	}
+	if status, _ := mcBinary(t, conn, r, mcOpSASLAuth, "PLAIN", []byte("\x00user\x00secret")); status != mcStatusOK {
+		t.Fatalf("auth: status 0x%02x", status)
+	}
+	if status, _ := mcBinary(t, conn, r, mcOpGet, "k", nil); status != mcStatusNotFound {
+		t.Fatalf("get after auth: status 0x%02x", status)
+	}
+}
+
+func TestMemcachedTouchVersion(t *testing.T) {
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	conn, r := dialMemcached(t, "")
+	if got := mcText(t, conn, r, "set k 0 0 1\r\nv\r\n"); got != "STORED" {
+		t.Fatalf("set = %q", got)
+	}
+	before, _ := g.lookup("k")
+	if got := mcText(t, conn, r, "touch k 100\r\n"); got != "TOUCHED" {
+		t.Fatalf("touch = %q", got)
+	}
+	after, _ := g.lookup("k")
+	if after.Version() <= before.Version() || after.Expire().IsZero() {
+		t.Fatalf("touch: version %d -> %d, expire %v", before.Version(), after.Version(), after.Expire())
+	}
+}
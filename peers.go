@@ -1,12 +1,94 @@
 package distributecache
 
+import (
+	consistenthash "DistributeCache/consistentHash"
+	"sync"
+)
+
 // 抽象出 2 个接口，PeerPicker 的 PickPeer() 方法用于根据传入的 key 选择相应节点 PeerGetter。
 // 接口 PeerGetter 的 Get() 方法用于从对应 group 查找缓存值。PeerGetter 就对应于上述流程中的 HTTP 客户端。
 type PeerPicker interface {
 	PickPeer(key string) string
 }
+
+// PeerPickerFunc 是 PeerPicker 的函数形式，例如可以直接使用一致性哈希的 Get 方法：PeerPickerFunc(peers.Get)。
+type PeerPickerFunc func(key string) string
+
+func (f PeerPickerFunc) PickPeer(key string) string {
+	return f(key)
+}
+
+// ReplicaPicker 在 PeerPicker 的基础上返回 key 的副本节点列表，用于复制和故障转移。
+// PickPeers 返回最多 n 个不同的节点地址，按优先级排列，第一个是主节点。
+type ReplicaPicker interface {
+	PeerPicker
+	PickPeers(key string, n int) []string
+}
+
+// PlacementPicker 使用 consistenthash.Placement 选择节点，并通过 SetNodes 记录节点所在的可用区，
+// PickPeers 优先选择位于不同可用区的节点。作为 NodeSetter 传给 SyncPeers 即可与成员列表保持同步。
+type PlacementPicker struct {
+	peers consistenthash.Placement
+
+	mu    sync.RWMutex
+	zones map[string]string // 节点地址到可用区
+}
+
+func NewPlacementPicker(peers consistenthash.Placement) *PlacementPicker {
+	return &PlacementPicker{peers: peers}
+}
+
+func (p *PlacementPicker) PickPeer(key string) string {
+	return p.peers.Get(key)
+}
+
+func (p *PlacementPicker) PickPeers(key string, n int) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.zones) == 0 {
+		return p.peers.GetN(key, n)
+	}
+	return consistenthash.GetNByZone(p.peers, key, n, func(node string) string {
+		return p.zones[node]
+	})
+}
+
+// SetNodes 记录节点所在的可用区，实现 NodeSetter。
+func (p *PlacementPicker) SetNodes(members []Member) {
+	zones := make(map[string]string, len(members))
+	for _, m := range members {
+		if m.Zone != "" {
+			zones[m.Addr] = m.Zone
+		}
+	}
+	p.mu.Lock()
+	p.zones = zones
+	p.mu.Unlock()
+}
+
+// LoadReporter 接收节点负载的变化，开启有界负载的 consistenthash.Map 使用它上报的负载选择节点。
+// 开始处理发往 node 的请求时调用 Inc，处理完成后调用 Done。
+type LoadReporter interface {
+	Inc(node string)
+	Done(node string)
+}
+
+// LoadUpdater 接收节点自己上报的负载，例如响应的 Header.Load。consistenthash.Map 实现了该接口，
+// 作为 LoadReporter 传给 APIServer 时，API 服务器在每次调用完成后用节点上报的负载校准本地的计数，
+// 使其他 API 服务器和前端发往该节点的请求也被计入。
+type LoadUpdater interface {
+	UpdateLoad(node string, load int64)
+}
+
 type PeerGetter interface {
 	Get(group string, key string) ([]byte, error)
-	Insert(group string, key string, value []byte)
+	Insert(group string, key string, value []byte) error
 	Delete(group string, key string) error
 }
+
+var (
+	_ ReplicaPicker = (*PlacementPicker)(nil)
+	_ ReplicaPicker = (*RPCRegistery)(nil)
+	_ ReplicaPicker = (*HTTPPool)(nil)
+	_ NodeSetter    = (*PlacementPicker)(nil)
+)
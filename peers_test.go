@@ -0,0 +1,37 @@
+package distributecache
+
+import (
+	consistenthash "DistributeCache/consistentHash"
+	"strconv"
+	"testing"
+)
+
+// TestPlacementPickerZones 检查设置了节点的可用区之后，PickPeers 把副本放在不同的可用区，
+// 可用区不够时使用同一个可用区的其他节点。
+func TestPlacementPickerZones(t *testing.T) {
+	ring := consistenthash.New(50, nil)
+	members := []Member{
+		{ID: "1", Node: Node{Addr: "tcp@a-0", Zone: "a"}},
+		{ID: "2", Node: Node{Addr: "tcp@a-1", Zone: "a"}},
+		{ID: "3", Node: Node{Addr: "tcp@a-2", Zone: "a"}},
+		{ID: "4", Node: Node{Addr: "tcp@b-0", Zone: "b"}},
+	}
+	zones := make(map[string]string)
+	for _, m := range members {
+		ring.Add(m.Addr)
+		zones[m.Addr] = m.Zone
+	}
+	p := NewPlacementPicker(ring)
+	p.SetNodes(members)
+	for i := 0; i < 1000; i++ {
+		key := "key-" + strconv.Itoa(i)
+		got := p.PickPeers(key, 2)
+		if len(got) != 2 || got[0] != p.PickPeer(key) || zones[got[0]] == zones[got[1]] {
+			t.Fatalf("PickPeers(%s, 2) = %v, want the owner and a node in the other zone", key, got)
+		}
+		got = p.PickPeers(key, 3)
+		if len(got) != 3 || got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+			t.Fatalf("PickPeers(%s, 3) = %v, want 3 distinct nodes", key, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package distributecache
+
+import (
+	"DistributeCache/codec"
+	"sync"
+)
+
+// ClientPool 缓存到各个节点的 RPC 客户端，同一个节点的所有调用复用同一条连接。
+// 连接断开后，下一次 Get 会重新建立连接。
+type ClientPool struct {
+	mu       sync.Mutex
+	opt      *Option
+	identity string // 不为空时每次建立连接前使用 secret 对 Option 签名
+	secret   []byte
+	clients  map[string]*Client
+}
+
+func NewClientPool(opt *Option) *ClientPool {
+	return &ClientPool{
+		opt:     opt,
+		clients: make(map[string]*Client),
+	}
+}
+
+// SetSigner 设置连接节点时使用的 HMAC 身份和密钥。
+// HMAC 签名带有时间戳，因此每次建立连接时都会重新签名，而不是复用同一个 Option。
+func (p *ClientPool) SetSigner(identity string, secret []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.identity = identity
+	p.secret = secret
+}
+
+// Get 返回到 rpcAddr（形如 tcp@localhost:1234）的可用客户端，没有时建立新的连接。
+// 建立连接时不持有锁，避免一个不可达的节点阻塞对其他节点的调用。
+func (p *ClientPool) Get(rpcAddr string) (*Client, error) {
+	p.mu.Lock()
+	client, ok := p.clients[rpcAddr]
+	if ok && client.IsAvailable() {
+		p.mu.Unlock()
+		return client, nil
+	}
+	if ok {
+		_ = client.Close()
+		delete(p.clients, rpcAddr)
+	}
+	opt := p.option()
+	p.mu.Unlock()
+
+	client, err := XDial(rpcAddr, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 建立连接期间其他协程可能已经建立了连接，此时使用已有的连接
+	if old, ok := p.clients[rpcAddr]; ok && old.IsAvailable() {
+		_ = client.Close()
+		return old, nil
+	}
+	p.clients[rpcAddr] = client
+	return client, nil
+}
+
+// option 返回建立连接时使用的 Option 的拷贝，XDial 会修改传入的 Option，调用方需要持有锁。
+func (p *ClientPool) option() *Option {
+	if p.opt == nil && p.identity == "" {
+		return nil
+	}
+	opt := *DefaultOption
+	if p.opt != nil {
+		opt = *p.opt
+	}
+	if p.identity != "" {
+		SignOption(&opt, p.identity, p.secret)
+	}
+	return &opt
+}
+
+// codecType 返回连接节点时使用的编解码方式。
+func (p *ClientPool) codecType() codec.Type {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.opt != nil && p.opt.CodecType != "" {
+		return p.opt.CodecType
+	}
+	return DefaultOption.CodecType
+}
+
+// Remove 关闭并移除到 rpcAddr 的客户端，通常在节点下线时调用。
+func (p *ClientPool) Remove(rpcAddr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client, ok := p.clients[rpcAddr]; ok {
+		_ = client.Close()
+		delete(p.clients, rpcAddr)
+	}
+}
+
+// Close 关闭所有客户端。
+func (p *ClientPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, client := range p.clients {
+		_ = client.Close()
+		delete(p.clients, addr)
+	}
+	return nil
+}
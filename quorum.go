@@ -0,0 +1,212 @@
+package distributecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Consistency 是一次读写需要得到多少个副本响应才算成功。
+type Consistency int
+
+const (
+	ConsistencyOne    Consistency = iota + 1 // 任意一个副本
+	ConsistencyQuorum                        // 多数副本，即 n/2+1 个
+	ConsistencyAll                           // 所有副本
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyOne:
+		return "ONE"
+	case ConsistencyQuorum:
+		return "QUORUM"
+	case ConsistencyAll:
+		return "ALL"
+	}
+	return "Consistency(" + strconv.Itoa(int(c)) + ")"
+}
+
+// ParseConsistency 解析 one、quorum 或 all，不区分大小写。
+func ParseConsistency(s string) (Consistency, error) {
+	switch strings.ToUpper(s) {
+	case "ONE":
+		return ConsistencyOne, nil
+	case "QUORUM":
+		return ConsistencyQuorum, nil
+	case "ALL":
+		return ConsistencyAll, nil
+	}
+	return 0, fmt.Errorf("unknown consistency level %q, use one, quorum or all", s)
+}
+
+// required 返回 n 个副本时需要成功的副本数。
+func (c Consistency) required(n int) int {
+	switch c {
+	case ConsistencyQuorum:
+		return n/2 + 1
+	case ConsistencyAll:
+		return n
+	}
+	return min(1, n)
+}
+
+// ErrQuorum 表示成功响应的副本数没有达到一致性级别的要求。
+var ErrQuorum = errors.New("not enough replicas responded")
+
+// VersionedValue 是带版本号的缓存值，用于副本之间的复制和 quorum 读写。
+type VersionedValue struct {
+	Key     string
+	Value   string
+	Version uint64
+	Expire  time.Time // 过期时间，零值表示永不过期
+	Flags   uint32    // 客户端随缓存值一起保存的标志位
+	Found   bool      // 读取时 key 是否存在
+	Deleted bool      // 删除标记，Version 是删除时分配的版本号，Found 为 false
+}
+
+// versionedValue 返回 key 的缓存值 value 对应的 VersionedValue，value 可以是删除标记。
+func versionedValue(key string, value ByteView) VersionedValue {
+	return VersionedValue{Key: key, Value: value.String(), Version: value.v, Expire: value.e, Flags: value.f, Found: !value.d, Deleted: value.d}
+}
+
+// view 返回 v 对应的缓存值，保留版本号、过期时间、标志位和删除标记。
+func (v *VersionedValue) view() ByteView {
+	return ByteView{b: []byte(v.Value), e: v.Expire, f: v.Flags, v: v.Version, d: v.Deleted}
+}
+
+// replicaSet 返回 key 在 group 中的所有副本节点。
+func (s *APIServer) replicaSet(group, key string) ([]string, error) {
+	s.mu.RLock()
+	n := max(s.replicas[group], 1)
+	s.mu.RUnlock()
+	var addrs []string
+	if picker, ok := s.peers.(ReplicaPicker); ok {
+		addrs = picker.PickPeers(key, n)
+	} else if addr := s.owner(key); addr != "" {
+		addrs = []string{addr}
+	}
+	if len(addrs) == 0 {
+		return nil, errNoPeer
+	}
+	return addrs, nil
+}
+
+// replicaResult 是一个副本对请求的响应。
+type replicaResult struct {
+	addr  string
+	value VersionedValue
+	err   error
+}
+
+// fanout 并发地向所有副本发送请求，等到 need 个副本成功或者已经不可能达到 need 个时返回成功的响应。
+// 剩余的请求在后台继续执行。
+func (s *APIServer) fanout(ctx context.Context, addrs []string, need int, call func(ctx context.Context, addr string) (VersionedValue, error)) ([]replicaResult, error) {
+	ch := make(chan replicaResult, len(addrs))
+	for _, addr := range addrs {
+		go func() {
+			v, err := call(ctx, addr)
+			ch <- replicaResult{addr: addr, value: v, err: err}
+		}()
+	}
+	var ok []replicaResult
+	var errs []error
+	for range addrs {
+		r := <-ch
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.addr, r.err))
+			if len(addrs)-len(errs) < need {
+				break
+			}
+			continue
+		}
+		if ok = append(ok, r); len(ok) == need {
+			return ok, nil
+		}
+	}
+	return ok, fmt.Errorf("%w: %d succeeded, %d required: %w", ErrQuorum, len(ok), need, errors.Join(errs...))
+}
+
+// quorumGet 按一致性级别 level 读取 key，返回响应的副本中版本号最大的值，
+// 并在后台把该值写回版本较旧或者没有该 key 的副本（读修复）。删除标记同样参与比较和读修复，
+// 版本号最大的是删除标记时返回 ErrNotFound；所有副本的缓存中都没有该 key 时与 level 为 0 时相同，由主节点从数据源加载。
+func (s *APIServer) quorumGet(ctx context.Context, group, key string, level Consistency) (VersionedValue, error) {
+	addrs, err := s.replicaSet(group, key)
+	if err != nil {
+		return VersionedValue{}, err
+	}
+	results, err := s.fanout(ctx, addrs, level.required(len(addrs)), func(ctx context.Context, addr string) (VersionedValue, error) {
+		var v VersionedValue
+		err := s.callNode(ctx, addr, group, "Group.GetVersioned", &key, &v)
+		return v, err
+	})
+	if err != nil {
+		return VersionedValue{}, err
+	}
+	var newest VersionedValue
+	for _, r := range results {
+		if present(r.value) && (!present(newest) || r.value.Version > newest.Version) {
+			newest = r.value
+		}
+	}
+	if !present(newest) {
+		return s.read(ctx, group, key, 0)
+	}
+	for _, r := range results {
+		if !present(r.value) || r.value.Version < newest.Version {
+			go s.repair(group, r.addr, newest)
+		}
+	}
+	if newest.Deleted {
+		return VersionedValue{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return newest, nil
+}
+
+// present 判断副本上是否有 key 的值或者删除标记。
+func present(v VersionedValue) bool {
+	return v.Found || v.Deleted
+}
+
+// repair 把 v 写入副本 addr，v 可以是删除标记。
+func (s *APIServer) repair(group, addr string, v VersionedValue) {
+	var reply string
+	if err := s.callNode(context.Background(), addr, group, "Group.Put", &v, &reply); err != nil {
+		log.Printf("api server: read repair %s on %s error: %v", v.Key, addr, err)
+	}
+}
+
+// quorumPut 为 value 分配版本号后写入 key 的所有副本，等到满足一致性级别 level 后返回。
+func (s *APIServer) quorumPut(ctx context.Context, group, key, value string, level Consistency) (VersionedValue, error) {
+	addrs, err := s.replicaSet(group, key)
+	if err != nil {
+		return VersionedValue{}, err
+	}
+	v := VersionedValue{Key: key, Value: value, Version: newVersion(), Found: true}
+	// 写操作在后台继续发送到其余副本，不能随请求结束而取消
+	_, err = s.fanout(context.WithoutCancel(ctx), addrs, level.required(len(addrs)), func(ctx context.Context, addr string) (VersionedValue, error) {
+		var reply string
+		return v, s.callNode(ctx, addr, group, "Group.Put", &v, &reply)
+	})
+	return v, err
+}
+
+// quorumDelete 为删除分配版本号，把删除标记写入 key 的所有副本，等到满足一致性级别 level 后返回。
+// 删除标记与写入的值一样按版本号比较，版本号更旧的写入到达副本时不会让 key 复活。
+func (s *APIServer) quorumDelete(ctx context.Context, group, key string, level Consistency) error {
+	addrs, err := s.replicaSet(group, key)
+	if err != nil {
+		return err
+	}
+	dead := tombstone()
+	v := versionedValue(key, dead)
+	_, err = s.fanout(context.WithoutCancel(ctx), addrs, level.required(len(addrs)), func(ctx context.Context, addr string) (VersionedValue, error) {
+		var reply string
+		return v, s.callNode(ctx, addr, group, "Group.Put", &v, &reply)
+	})
+	return err
+}
@@ -0,0 +1,210 @@
+package distributecache
+
+import (
+	consistenthash "DistributeCache/consistentHash"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTombstoneGroup(name string) *Group {
+	return NewGroup(name, 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded " + key), nil
+	}))
+}
+
+// TestPutAfterDelete 检查删除标记阻止版本号更旧的复制写入让被删除的值复活。
+func TestPutAfterDelete(t *testing.T) {
+	g := newTombstoneGroup(t.Name())
+	old := g.insert("k", ByteView{b: []byte("old")})
+	if err := g.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.lookup("k"); ok {
+		t.Fatal("deleted key is still visible")
+	}
+	dead, ok := g.lookupVersioned("k")
+	if !ok || !dead.d || dead.v <= old.v {
+		t.Fatalf("tombstone = %+v, want a tombstone newer than %d", dead, old.v)
+	}
+
+	// 删除之前写入的值乱序到达
+	if g.Put("k", old) {
+		t.Fatal("an older value overwrote the tombstone")
+	}
+	if _, ok := g.lookup("k"); ok {
+		t.Fatal("deleted key came back after an older Put")
+	}
+
+	newer := ByteView{b: []byte("new"), v: newVersion()}
+	if !g.Put("k", newer) {
+		t.Fatal("a newer value was rejected")
+	}
+	if v, ok := g.lookup("k"); !ok || v.String() != "new" {
+		t.Fatalf("lookup = %q, %v, want new", v, ok)
+	}
+}
+
+// TestLoadAfterDelete 检查删除之后从数据源加载的值继承删除标记的版本号。
+func TestLoadAfterDelete(t *testing.T) {
+	g := newTombstoneGroup(t.Name())
+	old := g.insert("k", ByteView{b: []byte("old")})
+	if _, err := g.delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := g.Get("k")
+	if err != nil || v.String() != "loaded k" {
+		t.Fatalf("Get = %q, %v, want the value from the data source", v, err)
+	}
+	if g.Put("k", old) {
+		t.Fatal("an older value overwrote the value loaded after the delete")
+	}
+}
+
+// TestDeleteMissingKey 检查删除不存在的 key 时返回 ErrNotFound，但仍然写入删除标记。
+func TestDeleteMissingKey(t *testing.T) {
+	g := newTombstoneGroup(t.Name())
+	dead, err := g.delete("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete = %v, want ErrNotFound", err)
+	}
+	if !dead.d || dead.v == 0 {
+		t.Fatalf("tombstone = %+v", dead)
+	}
+	if err := g.DeleteIfVersion("missing", dead.v); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteIfVersion on a tombstone = %v, want ErrNotFound", err)
+	}
+}
+
+// startQuorumNodes 启动 n 个节点，每个节点使用自己的 group 作为默认 group，
+// API 服务器以空的 group 名访问它们，使同一个进程中的副本互相独立。
+func startQuorumNodes(t *testing.T, n int) (*APIServer, map[string]*Group) {
+	t.Helper()
+	ring := consistenthash.New(10, nil)
+	groups := make(map[string]*Group, n)
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = lis.Close() })
+		addr := "tcp@" + lis.Addr().String()
+		g := newTombstoneGroup(t.Name() + "-" + strconv.Itoa(i))
+		groups[addr] = g
+		go NewServer(g, strconv.Itoa(i), addr).Accept(lis)
+		ring.Add(addr)
+	}
+	clients := NewClientPool(nil)
+	t.Cleanup(func() { _ = clients.Close() })
+	api := NewAPIServer(NewPlacementPicker(ring), clients)
+	api.SetReplicas("", n)
+	return api, groups
+}
+
+// TestQuorumReadTombstone 检查 quorum 读把删除标记视为最新的值，并把它修复到其他副本。
+func TestQuorumReadTombstone(t *testing.T) {
+	api, groups := startQuorumNodes(t, 2)
+	ctx := context.Background()
+	v, err := api.quorumPut(ctx, "", "k", "v", ConsistencyAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 删除只到达了一个副本
+	var stale *Group
+	for _, g := range groups {
+		if stale == nil {
+			stale = g
+			continue
+		}
+		if err := g.Delete("k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := api.quorumGet(ctx, "", "k", ConsistencyAll); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("quorum read = %v, want ErrNotFound", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if dead, ok := stale.lookupVersioned("k"); ok && dead.d {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the stale replica was not repaired with the tombstone")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	old := ByteView{b: []byte(v.Value), v: v.Version}
+	if stale.Put("k", old) {
+		t.Fatal("the repaired replica accepted the deleted value")
+	}
+}
+
+// TestQuorumDelete 检查 quorum 删除在所有副本上写入同一个删除标记，并且 Group.GetVersioned 只读取本地缓存。
+func TestQuorumDelete(t *testing.T) {
+	api, groups := startQuorumNodes(t, 2)
+	ctx := context.Background()
+	if _, err := api.quorumPut(ctx, "", "k", "v", ConsistencyAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.quorumDelete(ctx, "", "k", ConsistencyAll); err != nil {
+		t.Fatal(err)
+	}
+	var version uint64
+	for addr, g := range groups {
+		dead, ok := g.lookupVersioned("k")
+		if !ok || !dead.d {
+			t.Fatalf("%s: no tombstone after a quorum delete", addr)
+		}
+		if version != 0 && dead.v != version {
+			t.Fatalf("replicas hold tombstones %d and %d", version, dead.v)
+		}
+		version = dead.v
+	}
+
+	// 缓存中都没有的 key 由主节点从数据源加载，其余副本不会加载
+	got, err := api.quorumGet(ctx, "", "cold", ConsistencyAll)
+	if err != nil || got.Value != "loaded cold" {
+		t.Fatalf("quorum read of a cold key = %+v, %v", got, err)
+	}
+	loaded := 0
+	for _, g := range groups {
+		if _, ok := g.lookup("cold"); ok {
+			loaded++
+		}
+	}
+	if loaded != 1 {
+		t.Fatalf("%d replicas loaded the cold key, want only the owner", loaded)
+	}
+}
+
+// TestConflictVersion 检查条件写入和条件删除冲突时，主节点通过响应体返回 key 当前的版本号。
+func TestConflictVersion(t *testing.T) {
+	api, _ := startQuorumNodes(t, 2)
+	ctx := context.Background()
+	version, err := api.writeIf(ctx, "", "k", "v", &precondition{absent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = api.writeIf(ctx, "", "k", "w", &precondition{absent: true})
+	if v, ok := ConflictVersion(err); !ok || v != version || !errors.Is(err, ErrConflict) {
+		t.Fatalf("InsertIfAbsent on an existing key = %v, want a conflict at version %d", err, version)
+	}
+	_, err = api.writeIf(ctx, "", "k", "w", &precondition{version: version + 1})
+	if v, ok := ConflictVersion(err); !ok || v != version {
+		t.Fatalf("CompareAndSwap with a wrong version = %v, want a conflict at version %d", err, version)
+	}
+	err = api.removeIf(ctx, "", "k", &precondition{version: version + 1})
+	if v, ok := ConflictVersion(err); !ok || v != version {
+		t.Fatalf("DeleteIfVersion with a wrong version = %v, want a conflict at version %d", err, version)
+	}
+	if err := api.removeIf(ctx, "", "k", &precondition{version: version}); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.removeIf(ctx, "", "k", &precondition{version: version}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteIfVersion on a deleted key = %v, want ErrNotFound", err)
+	}
+}
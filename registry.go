@@ -0,0 +1,116 @@
+package distributecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Persist 从 path 恢复注册中心的成员列表，之后每次成员列表变化时把快照写入 path，
+// 使注册中心重启后不必等待所有节点的下一次心跳。
+// 快照只记录节点信息，不记录心跳时间，恢复的节点从恢复时开始计算超时时间。
+func (p *RPCRegistery) Persist(path string) error {
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var m Membership
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		p.restore(m)
+		log.Printf("rpc registry: restored %d servers from %s", len(m.Members), path)
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	go func() {
+		var saved uint64
+		for {
+			m, changed := p.membership()
+			if m.Revision != saved {
+				if err := writeSnapshot(path, m); err != nil {
+					log.Println("rpc registry: write snapshot error:", err)
+				} else {
+					saved = m.Revision
+				}
+			}
+			<-changed
+		}
+	}()
+	return nil
+}
+
+// restore 把快照中的节点加入成员列表，并使版本号大于快照中的版本号，使等待旧版本的客户端立即得到新的列表。
+func (p *RPCRegistery) restore(m Membership) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.merge(m.Members)
+	if p.rev <= m.Revision {
+		p.rev = m.Revision
+		p.bump()
+	}
+}
+
+// merge 加入或刷新 members 中的节点，调用方需要持有锁。
+func (p *RPCRegistery) merge(members []Member) {
+	for _, m := range members {
+		p.put(m)
+	}
+}
+
+// writeSnapshot 先写入临时文件再重命名，避免进程在写入过程中退出导致快照损坏。
+func writeSnapshot(path string, m Membership) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Follow 使注册中心成为 primary 的备用注册中心：通过长轮询复制 primary 的成员列表，直到 ctx 结束。
+// 复制只会加入节点并刷新它们的心跳时间，不会删除节点：primary 删除的节点在备用注册中心中超时后删除，
+// 主动下线的节点会同时向所有注册中心发送 DELETE。这样 primary 重启后成员列表为空时也不会清空备用注册中心。
+// 备用注册中心同样接受注册和心跳，primary 不可用时节点和客户端可以切换到备用注册中心。
+func (p *RPCRegistery) Follow(ctx context.Context, primary string) {
+	d := NewHTTPDiscovery(primary)
+	var rev uint64
+	for failures := 0; ; {
+		m, err := d.Members(ctx, rev)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("rpc registry: follow primary error:", err)
+			if !sleepContext(ctx, retryDelay(failures)) {
+				return
+			}
+			failures++
+			rev = 0
+			continue
+		}
+		failures = 0
+		rev = m.Revision
+		// 即使版本号没有变化也重新应用一次，刷新复制节点的心跳时间
+		p.mu.Lock()
+		p.merge(m.Members)
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,124 @@
+package distributecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor 每隔 10ms 检查一次 cond，5s 内没有满足时测试失败。
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hasMembers 判断 p 当前的成员是否恰好是 ids。
+func hasMembers(p *RPCRegistery, ids ...string) bool {
+	nodes := sortMembers(p.aliveNodes())
+	if len(nodes) != len(ids) {
+		return false
+	}
+	for i, m := range nodes {
+		if m.ID != ids[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRegistryPersist 检查重启后的注册中心从快照文件恢复成员列表，并且版本号大于快照中的版本号。
+func TestRegistryPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	p := NewRPCRegistery()
+	if err := p.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	p.putServer(Member{ID: "1", Node: Node{Addr: "tcp@127.0.0.1:1", Weight: 2, Zone: "a"}})
+	p.putServer(newMember("2", "tcp@127.0.0.1:2"))
+	var saved Membership
+	waitFor(t, "the snapshot with both servers", func() bool {
+		b, err := os.ReadFile(path)
+		return err == nil && json.Unmarshal(b, &saved) == nil && len(saved.Members) == 2
+	})
+
+	restarted := NewRPCRegistery()
+	if err := restarted.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if !hasMembers(restarted, "1", "2") {
+		t.Fatalf("restored members = %+v", restarted.aliveNodes())
+	}
+	m, _ := restarted.membership()
+	if m.Revision <= saved.Revision {
+		t.Fatalf("restored revision %d, want greater than the snapshot's %d", m.Revision, saved.Revision)
+	}
+	if m := sortMembers(m.Members)[0]; m.Weight != 2 || m.Zone != "a" {
+		t.Fatalf("restored node info = %+v", m)
+	}
+	if got := restarted.PickPeers("key", 2); len(got) != 2 {
+		t.Fatalf("restored servers are not in the placement: %v", got)
+	}
+
+	// 恢复之后的变化同样写入快照
+	restarted.mu.Lock()
+	restarted.remove("tcp@127.0.0.1:2")
+	restarted.mu.Unlock()
+	waitFor(t, "the snapshot without the removed server", func() bool {
+		b, err := os.ReadFile(path)
+		return err == nil && json.Unmarshal(b, &saved) == nil && len(saved.Members) == 1
+	})
+
+	if err := NewRPCRegistery().Persist(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("Persist without a snapshot = %v", err)
+	}
+}
+
+// TestRegistryFollow 检查备用注册中心复制 primary 的成员列表，primary 删除的节点在备用注册中心中等到超时才删除。
+func TestRegistryFollow(t *testing.T) {
+	primary := NewRPCRegistery()
+	server := httptest.NewServer(primary)
+	defer server.Close()
+	standby := NewRPCRegistery()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		standby.Follow(ctx, server.URL+defaultPath)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	primary.putServer(newMember("1", "tcp@127.0.0.1:1"))
+	waitFor(t, "the standby to copy the first server", func() bool { return hasMembers(standby, "1") })
+	primary.putServer(Member{ID: "2", Node: Node{Addr: "tcp@127.0.0.1:2", Zone: "b"}})
+	waitFor(t, "the standby to copy the second server", func() bool { return hasMembers(standby, "1", "2") })
+
+	primary.mu.Lock()
+	primary.remove("tcp@127.0.0.1:1")
+	primary.mu.Unlock()
+	// 等待 standby 收到 primary 的新版本后再检查
+	primary.putServer(newMember("3", "tcp@127.0.0.1:3"))
+	waitFor(t, "the standby to copy the third server", func() bool {
+		for _, m := range standby.aliveNodes() {
+			if m.ID == "3" {
+				return true
+			}
+		}
+		return false
+	})
+	if !hasMembers(standby, "1", "2", "3") {
+		t.Fatalf("standby members = %+v, want the removed server kept until it times out", standby.aliveNodes())
+	}
+}
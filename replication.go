@@ -0,0 +1,133 @@
+package distributecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReplicationMode 决定主节点转发写操作时是否等待副本。
+type ReplicationMode int
+
+const (
+	ReplicateAsync ReplicationMode = iota // 写入本地后立即返回，在后台转发到副本
+	ReplicateSync                         // 等待所有副本写入成功后返回，副本失败时返回 ErrReplication
+)
+
+// ErrReplication 表示同步复制时主节点已经写入，但是没有写入所有副本，即部分成功：
+// 主节点和成功的副本上是新值，失败的副本上仍然是旧值，直到下一次写入或者 quorum 读的读修复覆盖它。
+// 主节点上的写入不会回滚，客户端可以重试同一个写操作。
+var ErrReplication = errors.New("written on the primary but not on all replicas")
+
+// Replication 是 group 的复制配置，Factor 是包括主节点在内的副本数，小于等于 1 表示不复制。
+type Replication struct {
+	Factor int
+	Mode   ReplicationMode
+}
+
+// SetReplication 设置 group 的复制配置，只有 Server 设置了 Replicator 时才会生效。
+func (g *Group) SetReplication(r Replication) {
+	g.replication.Store(&r)
+}
+
+// Replication 返回 group 的复制配置。
+func (g *Group) Replication() Replication {
+	if r := g.replication.Load(); r != nil {
+		return *r
+	}
+	return Replication{Factor: 1}
+}
+
+const defaultReplicateTimeout = time.Second * 5
+
+// Replicator 把主节点上的写操作转发到 key 的其余副本节点。
+// 副本节点由 peers 选择，第一个是主节点，与 API 服务器选择节点使用的算法相同，
+// 副本节点收到的是 Group.Put，只修改本地缓存，不会再次转发。Group.Put 携带主节点分配的版本号、
+// 过期时间和标志位，删除以删除标记的形式复制，副本按版本号写入，乱序到达的旧值不会覆盖新值，也不会让删除的值复活。
+// 节点 RPC 和各个前端协议（RESP、memcached、gRPC、HTTP）的写操作都通过同一个 Replicator 转发。
+type Replicator struct {
+	self    string // 本节点在哈希环中的地址，即 Server.Addr
+	peers   ReplicaPicker
+	clients *ClientPool
+	timeout time.Duration
+}
+
+func NewReplicator(self string, peers ReplicaPicker, clients *ClientPool) *Replicator {
+	return &Replicator{
+		self:    self,
+		peers:   peers,
+		clients: clients,
+		timeout: defaultReplicateTimeout,
+	}
+}
+
+// replicas 返回 key 除本节点以外的副本节点。
+func (r *Replicator) replicas(g *Group, key string) []string {
+	factor := g.Replication().Factor
+	if factor <= 1 {
+		return nil
+	}
+	var addrs []string
+	for _, addr := range r.peers.PickPeers(key, factor) {
+		if addr != r.self {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// put 把主节点写入的 value 转发到 key 的副本节点，value 可以是删除标记，r 为空时不转发。
+func (r *Replicator) put(g *Group, key string, value ByteView) error {
+	if r == nil {
+		return nil
+	}
+	v := versionedValue(key, value)
+	return r.replicate(g, key, "Group.Put", &v)
+}
+
+// replicate 把对 key 的写操作 method 转发到副本节点，同步模式下副本失败时返回包装了 ErrReplication 的错误。
+func (r *Replicator) replicate(g *Group, key, method string, args interface{}) error {
+	addrs := r.replicas(g, key)
+	if len(addrs) == 0 {
+		return nil
+	}
+	if g.Replication().Mode == ReplicateAsync {
+		go func() {
+			if err := r.send(g.name, addrs, method, args); err != nil {
+				log.Println("rpc server: replicate error:", err)
+			}
+		}()
+		return nil
+	}
+	if err := r.send(g.name, addrs, method, args); err != nil {
+		return fmt.Errorf("%w: %w", ErrReplication, err)
+	}
+	return nil
+}
+
+// send 并发地向 addrs 发送请求，等待所有请求完成。
+func (r *Replicator) send(group string, addrs []string, method string, args interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := r.clients.Get(addr)
+			if err == nil {
+				var reply string
+				err = client.CallGroup(ctx, group, method, args, &reply)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("replica %s: %w", addr, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,237 @@
+package distributecache
+
+import (
+	"DistributeCache/cachepb"
+	consistenthash "DistributeCache/consistentHash"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var testReplicaSecret = []byte("replica secret")
+
+// methodRecorder 是记录副本节点收到的调用的授权策略，总是允许调用。
+type methodRecorder struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (r *methodRecorder) Allow(identity, method, group string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methods = append(r.methods, method)
+	return true
+}
+
+// take 返回并清空记录的调用。
+func (r *methodRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	methods := r.methods
+	r.methods = nil
+	return methods
+}
+
+// startReplica 启动一个副本节点，返回它的地址和它收到的调用。
+func startReplica(t *testing.T, g *Group) (string, *methodRecorder) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := "tcp@" + lis.Addr().String()
+	recorder := &methodRecorder{}
+	server := NewServer(g, "replica", addr)
+	server.SetAuth(&HMACAuthenticator{Secret: testReplicaSecret}, recorder)
+	go server.Accept(lis)
+	return addr, recorder
+}
+
+// newTestReplicator 创建把 g 的写操作同步转发到 replica 的 Replicator，两个节点时每个 key 都有一个副本。
+// 本节点的地址只用于在哈希环上排除自己，不会被连接。
+func newTestReplicator(t *testing.T, g *Group, replica string) *Replicator {
+	t.Helper()
+	g.SetReplication(Replication{Factor: 2, Mode: ReplicateSync})
+	ring := consistenthash.New(10, nil)
+	ring.Add("tcp@primary", replica)
+	clients := NewClientPool(nil)
+	clients.SetSigner("node", testReplicaSecret)
+	t.Cleanup(func() { _ = clients.Close() })
+	return NewReplicator("tcp@primary", NewPlacementPicker(ring), clients)
+}
+
+func newReplicatedGroup(t *testing.T) *Group {
+	return NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+}
+
+// expectReplicated 检查副本节点依次收到了 methods。
+func expectReplicated(t *testing.T, recorder *methodRecorder, op string, methods ...string) {
+	t.Helper()
+	if got := recorder.take(); !slices.Equal(got, methods) {
+		t.Errorf("%s: replica received %v, want %v", op, got, methods)
+	}
+}
+
+// TestReplicateFrontends 检查各个前端协议的写操作都转发到了副本节点。
+func TestReplicateFrontends(t *testing.T) {
+	g := newReplicatedGroup(t)
+	replica, recorder := startReplica(t, g)
+	r := newTestReplicator(t, g, replica)
+
+	t.Run("resp", func(t *testing.T) {
+		s := NewRESPServer(g.Name())
+		s.SetReplicator(r)
+		c := serveRESP(t, s)
+		if got := c.do(t, 1, "SET", "k", "v", "EX", "100"); got[0] != "+OK" {
+			t.Fatalf("SET = %q", got)
+		}
+		expectReplicated(t, recorder, "SET", "Group.Put")
+		if got := c.do(t, 1, "DEL", "k", "missing"); got[0] != ":1" {
+			t.Fatalf("DEL = %q", got)
+		}
+		expectReplicated(t, recorder, "DEL", "Group.Put", "Group.Put")
+	})
+
+	t.Run("memcached", func(t *testing.T) {
+		s := NewMemcachedServer(g.Name())
+		s.SetReplicator(r)
+		conn, rd := serveMemcached(t, s)
+		if got := mcText(t, conn, rd, "set k 5 100 1\r\nv\r\n"); got != "STORED" {
+			t.Fatalf("set = %q", got)
+		}
+		expectReplicated(t, recorder, "set", "Group.Put")
+		if got := mcText(t, conn, rd, "touch k 200\r\n"); got != "TOUCHED" {
+			t.Fatalf("touch = %q", got)
+		}
+		expectReplicated(t, recorder, "touch", "Group.Put")
+		if got := mcText(t, conn, rd, "delete k\r\n"); got != "DELETED" {
+			t.Fatalf("delete = %q", got)
+		}
+		expectReplicated(t, recorder, "delete", "Group.Put")
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewGRPCServer(g.Name())
+		s.SetReplicator(r)
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		client := cachepb.NewCacheClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := client.Set(ctx, &cachepb.SetRequest{Key: "k", Value: []byte("v")}); err != nil {
+			t.Fatal(err)
+		}
+		expectReplicated(t, recorder, "Set", "Group.Put")
+		if _, err := client.Delete(ctx, &cachepb.DeleteRequest{Key: "k"}); err != nil {
+			t.Fatal(err)
+		}
+		expectReplicated(t, recorder, "Delete", "Group.Put")
+	})
+
+	t.Run("http", func(t *testing.T) {
+		pool := NewHTTPPool("http://primary")
+		pool.SetReplicator(r)
+		server := httptest.NewServer(pool)
+		defer server.Close()
+		url := server.URL + defaultBasePath + g.Name() + "/k"
+		for _, method := range []string{http.MethodPut, http.MethodDelete} {
+			req, err := http.NewRequest(method, url, strings.NewReader("v"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("%s returned %s", method, resp.Status)
+			}
+		}
+		expectReplicated(t, recorder, "PUT and DELETE", "Group.Put", "Group.Put")
+	})
+}
+
+// TestReplicatePartialSuccess 检查同步复制失败时返回 ErrReplication，主节点上的写入保留。
+func TestReplicatePartialSuccess(t *testing.T) {
+	g := newReplicatedGroup(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica := "tcp@" + lis.Addr().String()
+	_ = lis.Close()
+	r := newTestReplicator(t, g, replica)
+
+	s := NewRESPServer(g.Name())
+	s.SetReplicator(r)
+	c := serveRESP(t, s)
+	got := c.do(t, 1, "SET", "k", "v")
+	if !strings.HasPrefix(got[0], "-ERR "+ErrReplication.Error()) {
+		t.Fatalf("SET = %q, want an ErrReplication error", got)
+	}
+	if v, ok := g.lookup("k"); !ok || v.String() != "v" {
+		t.Fatalf("primary value = %q, %v, want the written value", v, ok)
+	}
+
+	grpcErr := grpcError(r.put(g, "k", ByteView{b: []byte("v")}))
+	if status.Code(grpcErr) != codes.Unavailable {
+		t.Errorf("grpc error = %v, want Unavailable", grpcErr)
+	}
+}
+
+// TestPutKeepsExpireAndFlags 检查复制的值保留过期时间和标志位，读取的版本化值也带有它们。
+func TestPutKeepsExpireAndFlags(t *testing.T) {
+	g := newReplicatedGroup(t)
+	replica, _ := startReplica(t, g)
+	clients := NewClientPool(nil)
+	clients.SetSigner("node", testReplicaSecret)
+	defer clients.Close()
+	client, err := clients.Get(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expire := time.Now().Add(time.Hour).Round(0)
+	put := VersionedValue{Key: "k", Value: "v", Version: newVersion(), Expire: expire, Flags: 5, Found: true}
+	var reply string
+	if err := client.CallGroup(context.Background(), g.Name(), "Group.Put", &put, &reply); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := g.lookup("k")
+	if !ok || !v.Expire().Equal(expire) || v.Flags() != 5 {
+		t.Fatalf("stored value expire=%v flags=%d, want %v and 5", v.Expire(), v.Flags(), expire)
+	}
+
+	var got VersionedValue
+	key := "k"
+	if err := client.CallGroup(context.Background(), g.Name(), "Group.GetVersioned", &key, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Expire.Equal(expire) || got.Flags != 5 || got.Version != put.Version {
+		t.Fatalf("GetVersioned = %+v, want %+v", got, put)
+	}
+}
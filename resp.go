@@ -0,0 +1,474 @@
+package distributecache
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RESPServer 是兼容 Redis RESP2/RESP3 协议的前端，使 redis-cli 和现有的 Redis 客户端库可以直接访问本地的 Group。
+// 支持的命令：GET、SET（EX/PX）、DEL、MGET、EXISTS、PING、ECHO、INFO、HELLO、AUTH、QUIT，
+// 以及 SELECT group，把连接切换到名为 group 的 Group 上。
+// 设置了密码时与 Redis 的 requirepass 相同，连接需要先通过 AUTH 或者 HELLO ... AUTH 认证，用户名只能是 default。
+// 需要 TLS 时在 tls.NewListener 返回的监听上调用 Serve。
+type RESPServer struct {
+	group      string      // 新连接默认访问的 group
+	password   string      // 不为空时要求客户端认证
+	replicator *Replicator // 不为空时把写操作转发到副本节点
+}
+
+func NewRESPServer(defaultGroup string) *RESPServer {
+	return &RESPServer{group: defaultGroup}
+}
+
+// SetPassword 设置客户端认证使用的密码，需要在 Serve 之前调用。
+func (s *RESPServer) SetPassword(password string) {
+	s.password = password
+}
+
+// SetReplicator 设置转发写操作使用的 Replicator，通常与节点的 Server.SetReplicator 相同，需要在 Serve 之前调用。
+func (s *RESPServer) SetReplicator(r *Replicator) {
+	s.replicator = r
+}
+
+// Serve 在 lis 上等待连接，并为每个连接开启一个协程处理。
+func (s *RESPServer) Serve(lis net.Listener) error {
+	log.Println("resp server: accept addr:", lis.Addr().String())
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Println("resp server: accept error:", err)
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// respConn 保存一个连接的状态：当前的 group、协商的协议版本和是否已经认证。
+type respConn struct {
+	s      *RESPServer
+	r      *bufio.Reader
+	w      *bufio.Writer
+	group  string
+	proto  int  // 2 或 3，由 HELLO 协商，默认为 2
+	authed bool // 没有设置密码时总是为 true
+}
+
+var errRESPQuit = errors.New("resp: quit")
+
+// 与 Redis 相同，限制一条命令的参数个数和一行的长度。读取命令发生在认证之前，
+// 没有限制时一个未认证的客户端就能让服务端分配任意大的内存。
+const (
+	respMaxMultibulk = 1024 * 1024 // 一条命令最多的参数个数
+	respMaxInline    = 64 * 1024   // 一行最多的字节数，包括内联命令和数组、Bulk String 的长度行
+)
+
+// ServeConn 依次读取并执行命令，直到连接关闭或者客户端发送 QUIT。
+func (s *RESPServer) ServeConn(conn io.ReadWriteCloser) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	c := &respConn{
+		s:      s,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		group:  s.group,
+		proto:  2,
+		authed: s.password == "",
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("resp server: read command error:", err)
+				c.writeError("ERR Protocol error: " + err.Error())
+				_ = c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		err = c.execute(args)
+		// 客户端可能一次发送多条命令（pipeline），只在没有更多待处理的数据时刷新
+		if c.r.Buffered() == 0 || err != nil {
+			if ferr := c.w.Flush(); ferr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readCommand 读取一条命令，支持 RESP 数组形式和 telnet 使用的内联形式。
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > respMaxMultibulk {
+		return nil, fmt.Errorf("invalid multibulk length %q", line[1:])
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxValueBytes {
+			return nil, fmt.Errorf("invalid bulk length %q", line[1:])
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine 读取一行，超过 respMaxInline 时返回错误。
+func (c *respConn) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := c.r.ReadSlice('\n')
+		if len(line)+len(b) > respMaxInline {
+			return "", errors.New("too big inline request")
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *respConn) writeInt(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) writeBulk(b []byte) {
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+// writeNull 写入空值，RESP3 使用专门的 Null 类型，RESP2 使用长度为 -1 的 Bulk String。
+func (c *respConn) writeNull() {
+	if c.proto == 3 {
+		c.w.WriteString("_\r\n")
+		return
+	}
+	c.w.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArrayHeader(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMapHeader 写入包含 n 个键值对的 Map 的头部，RESP3 使用 Map 类型，RESP2 使用键值交替的数组。
+func (c *respConn) writeMapHeader(n int) {
+	if c.proto == 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		c.writeArrayHeader(2 * n)
+	}
+}
+
+func wrongArgs(cmd string) string {
+	return "ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command"
+}
+
+// execute 执行一条命令并写入响应，返回 errRESPQuit 时关闭连接。
+func (c *respConn) execute(args []string) error {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 1:
+			c.writeSimple("PONG")
+		case 2:
+			c.writeBulk([]byte(args[1]))
+		default:
+			c.writeError(wrongArgs(cmd))
+		}
+		return nil
+	case "ECHO":
+		if len(args) != 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		c.writeBulk([]byte(args[1]))
+		return nil
+	case "QUIT":
+		c.writeSimple("OK")
+		return errRESPQuit
+	case "HELLO":
+		c.hello(args)
+		return nil
+	case "AUTH":
+		switch len(args) {
+		case 2:
+			c.auth("default", args[1])
+		case 3:
+			c.auth(args[1], args[2])
+		default:
+			c.writeError(wrongArgs(cmd))
+		}
+		return nil
+	}
+	if !c.authed {
+		c.writeError("NOAUTH Authentication required.")
+		return nil
+	}
+	switch cmd {
+	case "SELECT":
+		if len(args) != 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		if GetGroup(args[1]) == nil {
+			c.writeError("ERR no such group " + args[1])
+			return nil
+		}
+		c.group = args[1]
+		c.writeSimple("OK")
+		return nil
+	case "COMMAND":
+		// redis-cli 启动时会发送 COMMAND DOCS，返回空数组即可
+		c.writeArrayHeader(0)
+		return nil
+	case "CLIENT":
+		// 客户端库连接时会发送 CLIENT SETNAME、CLIENT SETINFO 等命令，直接忽略
+		c.writeSimple("OK")
+		return nil
+	}
+
+	g := GetGroup(c.group)
+	if g == nil {
+		c.writeError("ERR no such group " + c.group)
+		return nil
+	}
+	switch cmd {
+	case "GET":
+		if len(args) != 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		c.get(g, args[1])
+	case "MGET":
+		if len(args) < 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		c.writeArrayHeader(len(args) - 1)
+		for _, key := range args[1:] {
+			c.get(g, key)
+		}
+	case "SET":
+		c.set(g, args)
+	case "DEL":
+		if len(args) < 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		var n int64
+		for _, key := range args[1:] {
+			dead, err := g.delete(key)
+			if err == nil {
+				n++
+			}
+			// 本地不存在时副本上仍然可能存在，同样需要把删除标记复制到副本
+			if err := c.s.replicator.put(g, key, dead); err != nil {
+				c.writeError("ERR " + err.Error())
+				return nil
+			}
+		}
+		c.writeInt(n)
+	case "EXISTS":
+		if len(args) < 2 {
+			c.writeError(wrongArgs(cmd))
+			return nil
+		}
+		// 只检查本地缓存，不存在的 key 不会触发从数据源加载
+		var n int64
+		for _, key := range args[1:] {
+			if _, ok := g.lookup(key); ok {
+				n++
+			}
+		}
+		c.writeInt(n)
+	case "INFO":
+		c.info(g)
+	default:
+		c.writeError("ERR unknown command '" + args[0] + "'")
+	}
+	return nil
+}
+
+// get 写入 key 的值，key 不存在时写入空值。
+func (c *respConn) get(g *Group, key string) {
+	view, err := g.Get(key)
+	switch {
+	case err == nil:
+		c.writeBulk(view.b)
+	case errors.Is(err, ErrNotFound):
+		c.writeNull()
+	default:
+		c.writeError("ERR " + err.Error())
+	}
+}
+
+// set 执行 SET key value [EX seconds | PX milliseconds]。
+func (c *respConn) set(g *Group, args []string) {
+	if len(args) != 3 && len(args) != 5 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	var ttl time.Duration
+	if len(args) == 5 {
+		n, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil || n <= 0 {
+			c.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+		switch strings.ToUpper(args[3]) {
+		case "EX":
+			ttl = time.Duration(n) * time.Second
+		case "PX":
+			ttl = time.Duration(n) * time.Millisecond
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	value := g.insertWithTTL(args[1], ByteView{b: []byte(args[2])}, ttl)
+	if err := c.s.replicator.put(g, args[1], value); err != nil {
+		c.writeError("ERR " + err.Error())
+		return
+	}
+	c.writeSimple("OK")
+}
+
+// auth 执行 AUTH [username] password，通过后连接可以执行其他命令。
+func (c *respConn) auth(username, password string) {
+	if c.checkAuth(username, password) {
+		c.writeSimple("OK")
+	}
+}
+
+// checkAuth 校验用户名和密码，失败时写入错误，返回是否通过。
+func (c *respConn) checkAuth(username, password string) bool {
+	if c.s.password == "" {
+		c.writeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return false
+	}
+	if username != "default" || subtle.ConstantTimeCompare([]byte(password), []byte(c.s.password)) != 1 {
+		c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	c.authed = true
+	return true
+}
+
+// hello 执行 HELLO [protover [AUTH username password] [SETNAME clientname]]，协商协议版本并返回服务信息。
+func (c *respConn) hello(args []string) {
+	proto := c.proto
+	if len(args) > 1 {
+		var err error
+		proto, err = strconv.Atoi(args[1])
+		if err != nil || (proto != 2 && proto != 3) {
+			c.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+	}
+	var username, password string
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				c.writeError("ERR Syntax error in HELLO option 'auth'")
+				return
+			}
+			username, password = args[i+1], args[i+2]
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				c.writeError("ERR Syntax error in HELLO option 'setname'")
+				return
+			}
+			i++
+		default:
+			c.writeError("ERR Syntax error in HELLO option '" + args[i] + "'")
+			return
+		}
+	}
+	if username != "" {
+		// 认证失败时 checkAuth 已经写入了错误
+		if !c.checkAuth(username, password) {
+			return
+		}
+	}
+	if !c.authed {
+		c.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	c.proto = proto
+	c.writeMapHeader(5)
+	for _, kv := range [][2]string{{"server", "distributecache"}, {"version", Version}} {
+		c.writeBulk([]byte(kv[0]))
+		c.writeBulk([]byte(kv[1]))
+	}
+	c.writeBulk([]byte("proto"))
+	c.writeInt(int64(c.proto))
+	for _, kv := range [][2]string{{"mode", "standalone"}, {"role", "master"}} {
+		c.writeBulk([]byte(kv[0]))
+		c.writeBulk([]byte(kv[1]))
+	}
+}
+
+// info 以 Redis INFO 的格式返回当前 group 的统计信息。
+func (c *respConn) info(g *Group) {
+	stats := g.Stats()
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	b.WriteString("redis_version:7.0.0\r\n")
+	b.WriteString("server_name:distributecache\r\n")
+	b.WriteString("# Memory\r\n")
+	fmt.Fprintf(&b, "used_memory:%d\r\n", stats.Bytes)
+	fmt.Fprintf(&b, "maxmemory:%d\r\n", stats.MaxBytes)
+	b.WriteString("# Stats\r\n")
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", stats.Hits)
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", stats.Misses)
+	b.WriteString("# Keyspace\r\n")
+	fmt.Fprintf(&b, "%s:keys=%d\r\n", g.Name(), stats.Keys)
+	c.writeBulk([]byte(b.String()))
+}
@@ -0,0 +1,143 @@
+package distributecache
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// respClient 是测试用的最小 RESP 客户端，按行读取回复。
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, password string) *respClient {
+	t.Helper()
+	s := NewRESPServer(t.Name())
+	s.SetPassword(password)
+	return serveRESP(t, s)
+}
+
+// serveRESP 在随机端口上启动 s 并建立一个连接。
+func serveRESP(t *testing.T, s *RESPServer) *respClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	go s.Serve(lis)
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do 发送一条命令，返回回复的前 n 行。
+func (c *respClient) do(t *testing.T, n int, args ...string) []string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, n)
+	for i := range lines {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return lines
+}
+
+func TestRESPAuth(t *testing.T) {
+	NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	c := dialRESP(t, "secret")
+	if got := c.do(t, 1, "GET", "k")[0]; !strings.HasPrefix(got, "-NOAUTH") {
+		t.Fatalf("GET before AUTH = %q", got)
+	}
+	if got := c.do(t, 1, "AUTH", "wrong")[0]; !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Fatalf("AUTH wrong = %q", got)
+	}
+	if got := c.do(t, 1, "AUTH", "default", "secret")[0]; got != "+OK" {
+		t.Fatalf("AUTH = %q", got)
+	}
+	if got := c.do(t, 2, "GET", "k"); got[1] != "value" {
+		t.Fatalf("GET after AUTH = %q", got)
+	}
+
+	// HELLO 可以同时完成认证
+	c = dialRESP(t, "secret")
+	got := c.do(t, 12, "HELLO", "3", "AUTH", "default", "secret")
+	if got[0] != "%5" || got[11] != ":3" {
+		t.Fatalf("HELLO 3 AUTH = %q", got)
+	}
+}
+
+func TestRESPExistsLocal(t *testing.T) {
+	loads := 0
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("value"), nil
+	}))
+	g.Insert("cached", ByteView{b: []byte("v")})
+	c := dialRESP(t, "")
+	if got := c.do(t, 1, "EXISTS", "cached", "missing")[0]; got != ":1" {
+		t.Fatalf("EXISTS = %q, want :1", got)
+	}
+	if loads != 0 {
+		t.Fatalf("EXISTS loaded %d keys from the getter", loads)
+	}
+}
+
+func TestRESPHelloProto(t *testing.T) {
+	c := dialRESP(t, "")
+	// RESP2 下 HELLO 回复键值交替的数组，proto 是整数
+	got := c.do(t, 12, "HELLO", "2")
+	if got[0] != "*10" || got[10] != "proto" || got[11] != ":2" {
+		t.Fatalf("HELLO 2 = %q", got)
+	}
+}
+
+// TestRESPLimits 检查服务端在认证之前拒绝参数个数过多的数组和过长的内联命令，并关闭连接。
+func TestRESPLimits(t *testing.T) {
+	for name, req := range map[string]string{
+		"multibulk": "*2000000000\r\n",
+		"inline":    strings.Repeat("a", respMaxInline+1) + "\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := dialRESP(t, "secret")
+			if _, err := c.conn.Write([]byte(req)); err != nil {
+				t.Fatal(err)
+			}
+			line, err := c.r.ReadString('\n')
+			if err != nil || !strings.HasPrefix(line, "-ERR Protocol error") {
+				t.Fatalf("reply = %q, %v, want a protocol error", line, err)
+			}
+			if _, err := c.r.ReadString('\n'); err == nil {
+				t.Fatal("connection is still open after a protocol error")
+			}
+		})
+	}
+
+	// 没有超过限制的内联命令正常执行
+	c := dialRESP(t, "")
+	if _, err := c.conn.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := c.r.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Fatalf("inline PING = %q, %v", line, err)
+	}
+}
@@ -2,9 +2,12 @@ package distributecache
 
 import (
 	consistenthash "DistributeCache/consistentHash"
+	"encoding/json"
+	"io"
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,13 +17,24 @@ const (
 	defaultRPCReplice = 10
 	defaultPath       = "/_geerpc_/registry"
 	defaultTimeout    = time.Minute * 5
+	defaultWaitTime   = time.Second * 30 // 长轮询最长的等待时间
+	membersPath       = "/members"
 )
 
+// Membership 是注册中心的成员列表快照，Revision 在成员加入、离开或者节点信息变化时递增。
+type Membership struct {
+	Revision uint64   `json:"revision"`
+	Members  []Member `json:"members"`
+}
+
 type RPCRegistery struct {
 	mu      sync.Mutex
-	peers   *consistenthash.Map
+	peers   consistenthash.Placement
 	timeout time.Duration
 	timeMap map[string]*(time.Time)
+	nodes   map[string]Member // 节点注册的信息，键是节点的地址
+	rev     uint64            // 成员列表的版本号
+	changed chan struct{}     // 成员列表变化时关闭，用于唤醒长轮询的请求
 }
 
 func NewRPCRegistery() *RPCRegistery {
@@ -28,14 +42,51 @@ func NewRPCRegistery() *RPCRegistery {
 		timeout: defaultTimeout,
 		peers:   consistenthash.New(defaultRPCReplice, nil),
 		timeMap: make(map[string]*(time.Time)),
+		nodes:   make(map[string]Member),
+		rev:     1, // 版本号从 1 开始，客户端可以用 0 表示不等待
+		changed: make(chan struct{}),
 	}
 	return p
 }
 
-func (p *RPCRegistery) set(peer string) {
-	p.peers.Add(peer)
-	now := time.Now()
-	p.timeMap[peer] = &now
+// SetPlacement 设置选择节点的算法：ring（默认）、rendezvous、jump 或 maglev，已经注册的节点会加入新的算法。
+func (p *RPCRegistery) SetPlacement(kind string) error {
+	peers, err := consistenthash.NewPlacement(kind, defaultRPCReplice, nil)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	weights := make(map[string]int, len(p.timeMap))
+	for addr := range p.timeMap {
+		weights[addr] = max(p.nodes[addr].Weight, 1)
+	}
+	peers.SetWeights(weights)
+	p.peers = peers
+	return nil
+}
+
+// bump 递增版本号并唤醒所有等待的请求，调用方需要持有锁。
+func (p *RPCRegistery) bump() {
+	p.rev++
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// set 按照节点注册的权重把节点加入哈希环，权重为 0 的旧版本节点视为 1，调用方需要持有锁。
+func (p *RPCRegistery) set(m Member) {
+	p.peers.SetWeights(map[string]int{m.Addr: max(m.Weight, 1)})
+}
+
+// remove 删除节点，调用方需要持有锁。
+func (p *RPCRegistery) remove(addr string) {
+	if _, ok := p.timeMap[addr]; !ok {
+		return
+	}
+	defer p.bump()
+	delete(p.timeMap, addr)
+	delete(p.nodes, addr)
+	p.peers.Remove(addr)
 }
 
 func (p *RPCRegistery) PickPeer(key string) string {
@@ -46,8 +97,7 @@ func (p *RPCRegistery) PickPeer(key string) string {
 	if rpcAddr != "" {
 		if p.timeMap[rpcAddr].Add(p.timeout).Before(time.Now()) {
 			log.Printf("peer %s timeout", rpcAddr)
-			delete(p.timeMap, rpcAddr)
-			p.peers.Remove(rpcAddr)
+			p.remove(rpcAddr)
 			return ""
 		}
 	}
@@ -71,17 +121,34 @@ func (p *RPCRegistery) PickPeer(key string) string {
 	return rpcAddr
 }
 
-var _ PeerPicker = (*RPCRegistery)(nil)
+// PickPeers 返回 key 的 n 个副本节点，第一个是主节点，优先选择注册信息中位于不同可用区的节点。
+func (p *RPCRegistery) PickPeers(key string, n int) []string {
+	p.aliveServers() // 删除超时的节点
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return consistenthash.GetNByZone(p.peers, key, n, func(addr string) string {
+		return p.nodes[addr].Zone
+	})
+}
 
-// putServer：添加服务实例，如果服务已经存在，则更新 start。
-func (p *RPCRegistery) putServer(addr string) {
+// putServer：添加服务实例，如果服务已经存在，则更新 start 和节点信息。
+func (p *RPCRegistery) putServer(m Member) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	s := p.timeMap[addr]
-	if s == nil {
-		p.set(addr)
-	} else {
-		*s = time.Now()
+	p.put(m)
+}
+
+// put 是 putServer 的实现，调用方需要持有锁。
+func (p *RPCRegistery) put(m Member) {
+	now := time.Now()
+	p.timeMap[m.Addr] = &now
+	// 单纯的心跳不修改哈希环，也不改变版本号
+	if old, ok := p.nodes[m.Addr]; !ok || old.ID != m.ID || !old.equal(m.Node) {
+		if !ok || old.Weight != m.Weight {
+			p.set(m)
+		}
+		p.nodes[m.Addr] = m
+		p.bump()
 	}
 }
 
@@ -94,28 +161,112 @@ func (p *RPCRegistery) aliveServers() []string {
 		if p.timeout == 0 || s.Add(p.timeout).After(time.Now()) {
 			alive = append(alive, addr)
 		} else {
-			delete(p.timeMap, addr)
-			p.peers.Remove(addr)
+			p.remove(addr)
 		}
 	}
 	sort.Strings(alive)
 	return alive
 }
 
-// Get：返回所有可用的服务列表，通过自定义字段 X-Geerpc-Servers 承载。
-// Post：添加服务实例或发送心跳，通过自定义字段 X-Geerpc-Server 承载。
+// aliveNodes：返回可用服务的节点信息。
+func (p *RPCRegistery) aliveNodes() []Member {
+	alive := p.aliveServers()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodes := make([]Member, 0, len(alive))
+	for _, addr := range alive {
+		if m, ok := p.nodes[addr]; ok {
+			nodes = append(nodes, m)
+		}
+	}
+	return nodes
+}
+
+// membership 返回当前的成员列表快照，以及下一次变化时会被关闭的 channel。
+// 先读取版本号再读取成员列表，两者之间发生的变化会让客户端的下一次请求立即返回，而不会被遗漏。
+func (p *RPCRegistery) membership() (Membership, <-chan struct{}) {
+	p.mu.Lock()
+	rev, changed := p.rev, p.changed
+	p.mu.Unlock()
+	return Membership{Revision: rev, Members: p.aliveNodes()}, changed
+}
+
+// serveMembers 返回 JSON 编码的 Membership。
+// 携带 ?wait=rev 时，如果当前版本号等于 rev，则阻塞到成员列表变化或者等待超时后再返回，
+// 等待时间默认为 defaultWaitTime，可以通过 ?timeout=10s 缩短。
+func (p *RPCRegistery) serveMembers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	m, changed := p.membership()
+	if v := q.Get("wait"); v != "" {
+		wait, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid wait: "+v, http.StatusBadRequest)
+			return
+		}
+		timeout := defaultWaitTime
+		if v := q.Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "invalid timeout: "+v, http.StatusBadRequest)
+				return
+			}
+			timeout = min(d, defaultWaitTime)
+		}
+		if m.Revision == wait {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			select {
+			case <-changed:
+				m, _ = p.membership()
+			case <-t.C:
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Geerpc-Revision", strconv.FormatUint(m.Revision, 10))
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		log.Println("rpc registry: encode membership error:", err)
+	}
+}
+
+// 路径以 /members 结尾的请求由 serveMembers 处理，返回带版本号的成员列表。
+// Get：返回所有可用的服务列表，通过自定义字段 X-Geerpc-Servers 承载，响应体是 JSON 编码的节点信息列表。
+// Post：添加服务实例或发送心跳，通过自定义字段 X-Geerpc-Server 承载，请求体可以是 JSON 编码的 Member。
 func (p *RPCRegistery) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, membersPath) {
+		p.serveMembers(w, req)
+		return
+	}
 	switch req.Method {
 	case "GET":
 		w.Header().Set("X-Geerpc-Servers", strings.Join(p.aliveServers(), ","))
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p.aliveNodes()); err != nil {
+			log.Println("rpc registry: encode nodes error:", err)
+		}
 	case "POST":
 		addr := req.Header.Get("X-Geerpc-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		// 只携带地址的旧版本心跳使用地址作为 ID
+		m := newMember(addr, addr)
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&m); err != nil && err != io.EOF {
+				http.Error(w, "invalid node: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.Addr = addr
+		}
 		log.Println("rpc registry: ServeHTTP putServer ", addr)
-		p.putServer(addr)
+		p.putServer(m)
 		log.Println("rpc registry: ServeHTTP putServer end", addr)
 	case "DELETE":
 		addr := req.Header.Get("X-Geerpc-Server")
@@ -124,8 +275,9 @@ func (p *RPCRegistery) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 		log.Println("rpc registry: ServeHTTP removeServer ", addr)
-		delete(p.timeMap, addr)
-		p.peers.Remove(addr)
+		p.mu.Lock()
+		p.remove(addr)
+		p.mu.Unlock()
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -133,6 +285,7 @@ func (p *RPCRegistery) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (p *RPCRegistery) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, p)
+	http.Handle(registryPath+membersPath, p)
 	log.Printf("rpc registry path: %s", registryPath)
 }
 
@@ -162,8 +315,7 @@ func (p *RPCRegistery) Cleanup() {
 			p.mu.Lock()
 			for addr, s := range p.timeMap {
 				if s.Add(p.timeout).Before(time.Now()) {
-					delete(p.timeMap, addr)
-					p.peers.Remove(addr)
+					p.remove(addr)
 					log.Printf("rpc registry: remove expired server %s", addr)
 				}
 			}
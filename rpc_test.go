@@ -0,0 +1,161 @@
+package distributecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// registryShare 返回 keys 个 key 中属于 addr 的比例。
+func registryShare(p *RPCRegistery, addr string, keys int) float64 {
+	n := 0
+	for i := 0; i < keys; i++ {
+		if p.PickPeer("key-"+strconv.Itoa(i)) == addr {
+			n++
+		}
+	}
+	return float64(n) / float64(keys)
+}
+
+// TestRegistryWeight 检查注册中心按照节点注册的权重分配 key，单纯的心跳不会重置权重。
+func TestRegistryWeight(t *testing.T) {
+	p := NewRPCRegistery()
+	heavy := Member{ID: "heavy", Node: Node{Addr: "tcp@127.0.0.1:1", Weight: 3}}
+	p.putServer(heavy)
+	p.putServer(newMember("light", "tcp@127.0.0.1:2"))
+	share := registryShare(p, heavy.Addr, 20000)
+	if share < 0.6 || share > 0.9 {
+		t.Fatalf("node with weight 3 owns %.3f of the keys, want about 0.75", share)
+	}
+
+	rev := p.rev
+	p.putServer(heavy)
+	if got := registryShare(p, heavy.Addr, 20000); got != share || p.rev != rev {
+		t.Fatalf("heartbeat changed the share from %.3f to %.3f, revision %d -> %d", share, got, rev, p.rev)
+	}
+
+	heavy.Weight = 1
+	p.putServer(heavy)
+	if got := registryShare(p, heavy.Addr, 20000); got > 0.65 {
+		t.Fatalf("after lowering the weight the node owns %.3f of the keys", got)
+	}
+}
+
+// pollMembers 在新的协程中以 ?wait=rev 请求成员列表，返回接收结果的 channel。
+func pollMembers(t *testing.T, url string, rev uint64, timeout time.Duration) <-chan Membership {
+	t.Helper()
+	ch := make(chan Membership, 1)
+	go func() {
+		resp, err := http.Get(url + membersPath + "?wait=" + strconv.FormatUint(rev, 10) + "&timeout=" + timeout.String())
+		if err != nil {
+			t.Error(err)
+			close(ch)
+			return
+		}
+		defer resp.Body.Close()
+		var m Membership
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Error(err)
+			close(ch)
+			return
+		}
+		ch <- m
+	}()
+	return ch
+}
+
+// TestRegistryLongPoll 检查 ?wait=rev 在版本号不变时阻塞，成员注册或注销时立即返回，超时后返回当前的成员列表。
+func TestRegistryLongPoll(t *testing.T) {
+	p := NewRPCRegistery()
+	server := httptest.NewServer(p)
+	defer server.Close()
+	url := server.URL + defaultPath
+	d := NewHTTPDiscovery(url)
+	ctx := context.Background()
+
+	m, err := d.Members(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := Member{ID: "1", Node: Node{Addr: "tcp@127.0.0.1:1", Zone: "a"}}
+	for _, step := range []struct {
+		name    string
+		change  func() error
+		members int
+	}{
+		{"register", func() error { return d.Register(ctx, node) }, 1},
+		{"deregister", func() error { return d.Deregister(ctx, node) }, 0},
+	} {
+		ch := pollMembers(t, url, m.Revision, time.Minute)
+		select {
+		case got := <-ch:
+			t.Fatalf("%s: long poll returned %+v before the members changed", step.name, got)
+		case <-time.After(100 * time.Millisecond):
+		}
+		start := time.Now()
+		if err := step.change(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-ch:
+			if got.Revision <= m.Revision || len(got.Members) != step.members {
+				t.Fatalf("%s: long poll returned %+v after revision %d", step.name, got, m.Revision)
+			}
+			m = got
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: long poll did not wake up", step.name)
+		}
+		if waited := time.Since(start); waited > time.Second {
+			t.Fatalf("%s: long poll woke up after %s", step.name, waited)
+		}
+	}
+
+	start := time.Now()
+	select {
+	case got := <-pollMembers(t, url, m.Revision, 50*time.Millisecond):
+		if got.Revision != m.Revision {
+			t.Fatalf("long poll timed out with revision %d, want %d", got.Revision, m.Revision)
+		}
+		if waited := time.Since(start); waited < 50*time.Millisecond {
+			t.Fatalf("long poll returned after %s, before the timeout", waited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll did not return after the timeout")
+	}
+}
+
+// TestHTTPDiscoveryWatch 检查 Watch 通过长轮询在成员注册和注销后发送新的快照。
+func TestHTTPDiscoveryWatch(t *testing.T) {
+	server := httptest.NewServer(NewRPCRegistery())
+	defer server.Close()
+	d := NewHTTPDiscovery(server.URL + defaultPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nextSnapshot(t, ch); len(got) != 0 {
+		t.Fatalf("first snapshot = %v, want no members", got)
+	}
+	node := Member{ID: "1", Node: Node{Addr: "tcp@127.0.0.1:1", Weight: 2}}
+	if err := d.Register(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextSnapshot(t, ch); len(got) != 1 || got[0].ID != "1" || got[0].Weight != 2 {
+		t.Fatalf("snapshot after Register = %+v", got)
+	}
+	if err := d.Deregister(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextSnapshot(t, ch); len(got) != 0 {
+		t.Fatalf("snapshot after Deregister = %+v", got)
+	}
+	cancel()
+	for range ch {
+	}
+}
@@ -0,0 +1,380 @@
+package distributecache
+
+import (
+	"DistributeCache/codec"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// 流式调用把响应拆分成多个帧发送，每一帧都是一个 Header{Stream: true} 加一段 []byte 的 Body，
+// 除最后一帧外 More 都为 true。服务端每发送一帧都会单独获取 sending 锁，
+// 因此同一连接上其他调用的响应可以穿插在帧之间发送，较大的值不会长时间阻塞整个连接。
+// | Header{Seq: 1, Stream, More} | chunk | Header{Seq: 2} | Body | Header{Seq: 1, Stream} | chunk |
+//
+// 流量控制：客户端在握手时通过 Option.StreamWindow 声明窗口大小，服务端的每个流式响应最多发送 StreamWindow 个
+// 尚未确认的帧，之后暂停发送。客户端每处理完 streamAckEvery 帧数据发送一个 Header{Seq, Ack: true}，Body 是处理完的帧数；
+// 放弃读取后再收到该调用的帧时发送 Body 为 -1 的确认帧，服务端随即结束该流式响应。
+// 这样读取较慢的客户端只会让对应的流式响应暂停，既不会在客户端无限制地缓存数据，也不会阻塞同一连接上的其他调用。
+
+const (
+	defaultChunkSize = 64 << 10 // 每一帧携带的最大数据量
+	streamThreshold  = 1 << 20  // Group.Get 的结果超过该大小时使用流式响应
+	dumpBatch        = 256      // Group.Dump 每次从缓存中取出的条目数
+
+	defaultStreamWindow = 16                      // 每个流式响应最多缓存在客户端的帧数，即 1MB 数据
+	streamAckEvery      = defaultStreamWindow / 2 // 客户端每处理完这么多帧发送一次确认
+)
+
+// Entry 是批量传输缓存数据时使用的键值对。
+type Entry struct {
+	Key     string
+	Value   []byte
+	Expire  time.Time // 过期时间，零值表示永不过期
+	Flags   uint32
+	Version uint64 // 值的版本号，写入时按版本号决定是否覆盖已有的值
+}
+
+// sendStream 把 r 中的数据切分成帧依次发送，读取 r 出错时在最后一帧的 Header.Error 中返回错误。
+// 发送每一帧之前调用 acquire 等待发送窗口，acquire 返回 false 时客户端已经放弃读取或者连接已经断开，不再发送。
+func (server *Server) sendStream(cc codec.Codec, h *codec.Header, r io.Reader, sending *sync.Mutex, acquire func() bool) {
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	buf := make([]byte, defaultChunkSize)
+	for {
+		if !acquire() {
+			return
+		}
+		n, err := io.ReadFull(r, buf)
+		frame := *h
+		frame.Stream = true
+		frame.More = err == nil
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Println("rpc server: read stream error:", err)
+			setError(&frame, err)
+		}
+		sending.Lock()
+		werr := cc.Write(&frame, buf[:n])
+		sending.Unlock()
+		if werr != nil {
+			log.Println("rpc server: write stream frame error: ", werr)
+			return
+		}
+		if !frame.More {
+			return
+		}
+	}
+}
+
+// dump 返回键以 prefix 开头的所有缓存条目，条目依次以 gob 编码写入返回的数据流中。
+// 先取出所有的 key，再每次取出 dumpBatch 个条目编码，管道的读取方（即 sendStream）受流量控制阻塞时，
+// 编码也随之暂停，因此任何时候只有一批条目在内存中，也不会长时间持有缓存的锁。
+func (g *Group) dump(prefix string) io.Reader {
+	keys := g.mainCache.keys(prefix)
+	pr, pw := io.Pipe()
+	go func() {
+		enc := gob.NewEncoder(pw)
+		for len(keys) > 0 {
+			n := min(len(keys), dumpBatch)
+			entries := g.mainCache.entries(keys[:n])
+			keys = keys[n:]
+			for i := range entries {
+				if err := enc.Encode(&entries[i]); err != nil {
+					_ = pw.CloseWithError(err)
+					return
+				}
+			}
+		}
+		_ = pw.Close()
+	}()
+	return pr
+}
+
+// streamWindows 记录一个连接上所有流式响应的发送窗口，为空时不做流量控制。
+type streamWindows struct {
+	size    int
+	mu      sync.Mutex
+	windows map[uint64]*streamWindow
+	closed  chan struct{} // 连接的读取循环退出时关闭，之后不会再收到确认帧
+}
+
+// streamWindow 是一个流式响应的发送窗口，credits 中的元素个数即为已发送但尚未确认的帧数。
+type streamWindow struct {
+	credits  chan struct{}
+	canceled chan struct{}
+	once     sync.Once
+}
+
+func newStreamWindows(size int) *streamWindows {
+	if size <= 0 {
+		return nil
+	}
+	return &streamWindows{size: size, windows: make(map[uint64]*streamWindow), closed: make(chan struct{})}
+}
+
+// open 为调用 seq 的流式响应创建发送窗口。
+func (ws *streamWindows) open(seq uint64) *streamWindow {
+	if ws == nil {
+		return nil
+	}
+	w := &streamWindow{credits: make(chan struct{}, ws.size), canceled: make(chan struct{})}
+	ws.mu.Lock()
+	ws.windows[seq] = w
+	ws.mu.Unlock()
+	return w
+}
+
+// release 在流式响应结束后移除它的发送窗口。
+func (ws *streamWindows) release(seq uint64) {
+	if ws == nil {
+		return
+	}
+	ws.mu.Lock()
+	delete(ws.windows, seq)
+	ws.mu.Unlock()
+}
+
+// acquire 占用窗口中的一帧，窗口已满时阻塞，直到客户端确认、放弃读取或者连接断开。
+func (ws *streamWindows) acquire(w *streamWindow) bool {
+	if ws == nil {
+		return true
+	}
+	select {
+	case w.credits <- struct{}{}:
+		return true
+	case <-w.canceled:
+		return false
+	case <-ws.closed:
+		return false
+	}
+}
+
+// ack 处理客户端对调用 seq 的确认，n 为 -1 时结束该流式响应。
+func (ws *streamWindows) ack(seq uint64, n int64) {
+	if ws == nil {
+		return
+	}
+	ws.mu.Lock()
+	w := ws.windows[seq]
+	ws.mu.Unlock()
+	if w == nil {
+		return
+	}
+	if n < 0 {
+		w.once.Do(func() { close(w.canceled) })
+		return
+	}
+	for ; n > 0; n-- {
+		select {
+		case <-w.credits:
+		default:
+			return
+		}
+	}
+}
+
+func (ws *streamWindows) close() {
+	if ws != nil {
+		close(ws.closed)
+	}
+}
+
+// StreamReader 按顺序读取流式调用收到的数据帧。
+// 接收协程只会把数据帧追加到队列中而不会阻塞，因此读取较慢也不会影响同一连接上的其他调用；
+// 队列的长度受服务端的发送窗口限制，每读完 streamAckEvery 帧向服务端确认一次。
+type StreamReader struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	chunks   [][]byte
+	consumed int64         // 已经读完但尚未确认的帧数
+	err      error         // 流结束的原因，正常结束时为 io.EOF
+	cancel   func()        // 放弃读取时取消对应的调用
+	ack      func(n int64) // 向服务端确认读完的帧数
+}
+
+func newStreamReader() *StreamReader {
+	s := &StreamReader{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *StreamReader) write(chunk []byte) {
+	if len(chunk) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.chunks = append(s.chunks, chunk)
+		s.cond.Signal()
+	}
+}
+
+// closeWithError 结束数据流，err 为空表示正常结束。只有第一次调用生效。
+func (s *StreamReader) closeWithError(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.cond.Broadcast()
+	}
+}
+
+// Read 读取已收到的数据，没有数据时阻塞等待，数据流结束后返回 io.EOF 或调用的错误。
+func (s *StreamReader) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.chunks) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.chunks) == 0 {
+		s.mu.Unlock()
+		return 0, s.err
+	}
+	n := copy(p, s.chunks[0])
+	var acked int64
+	if n == len(s.chunks[0]) {
+		s.chunks[0] = nil
+		s.chunks = s.chunks[1:]
+		if s.consumed++; s.consumed >= streamAckEvery {
+			acked, s.consumed = s.consumed, 0
+		}
+	} else {
+		s.chunks[0] = s.chunks[0][n:]
+	}
+	s.mu.Unlock()
+	if acked > 0 && s.ack != nil {
+		s.ack(acked)
+	}
+	return n, nil
+}
+
+// Close 放弃读取剩余的数据，之后收到的数据帧会被丢弃。
+func (s *StreamReader) Close() error {
+	s.closeWithError(errors.New("rpc client: stream closed"))
+	s.mu.Lock()
+	s.chunks = nil
+	s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+var _ io.ReadCloser = (*StreamReader)(nil)
+
+// readFrame 读取一个流式帧的数据，追加到 call 的数据流中。
+// call 为空时调用已经被放弃，丢弃该帧并通知服务端停止发送；普通调用收到的帧直接确认。
+func (client *Client) readFrame(h *codec.Header, call *Call) error {
+	var chunk []byte
+	if err := client.cc.ReadBody(&chunk); err != nil {
+		return err
+	}
+	switch {
+	case call == nil:
+		if h.More {
+			client.sendAck(h.Seq, -1)
+		}
+	case call.stream != nil:
+		call.stream.write(chunk)
+	default:
+		call.buf = append(call.buf, chunk...)
+		client.sendAck(h.Seq, 1)
+	}
+	return nil
+}
+
+// sendAck 向服务端确认调用 seq 的 n 帧数据。在新的协程中发送，避免阻塞接收协程。
+func (client *Client) sendAck(seq uint64, n int64) {
+	go func() {
+		if err := client.sendControl(codec.Header{Seq: seq, Ack: true}, n); err != nil {
+			log.Println("rpc client: send stream ack error:", err)
+		}
+	}()
+}
+
+// setStreamReply 把普通调用收到的流式响应数据写入 reply 中。
+func setStreamReply(reply interface{}, data []byte) error {
+	switch r := reply.(type) {
+	case *string:
+		*r = string(data)
+	case *[]byte:
+		*r = data
+	default:
+		return fmt.Errorf("rpc client: cannot set stream reply into %T", reply)
+	}
+	return nil
+}
+
+// Stream 发起一次流式调用，返回的 StreamReader 按顺序读取服务端分帧发送的数据。
+// 适用于 Group.GetStream、Group.Dump 等总是以流式响应返回结果的方法。
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args interface{}) (*StreamReader, error) {
+	return client.StreamGroup(ctx, "", serviceMethod, args)
+}
+
+// StreamGroup 与 Stream 相同，但是访问服务端名为 group 的 Group。
+func (client *Client) StreamGroup(ctx context.Context, group, serviceMethod string, args interface{}) (*StreamReader, error) {
+	stream := newStreamReader()
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Group:         group,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		stream:        stream,
+	}
+	client.send(call)
+	select {
+	case <-call.Done:
+		// 请求发送失败，或者调用已经结束
+		if call.Error != nil {
+			return nil, call.Error
+		}
+		stream.closeWithError(nil)
+		return stream, nil
+	default:
+	}
+	stream.cancel = func() { client.removeCall(call.Seq) }
+	stream.ack = func(n int64) { client.sendAck(call.Seq, n) }
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.removeCall(call.Seq)
+			stream.closeWithError(fmt.Errorf("rpc client: call failed: %w", ctx.Err()))
+		case call := <-call.Done:
+			stream.closeWithError(call.Error)
+		}
+	}()
+	return stream, nil
+}
+
+// PullEntries 通过 Group.Dump 从对端拉取 g 中键以 prefix 开头的缓存数据并写入本地的 g，返回写入的条目数。
+// 用于节点之间批量迁移缓存数据。条目保留原来的版本号，本地已有版本号更大的值时不覆盖（参见 Group.Put）。
+func (client *Client) PullEntries(ctx context.Context, g *Group, prefix string) (int, error) {
+	stream, err := client.StreamGroup(ctx, g.name, "Group.Dump", &prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	dec := gob.NewDecoder(stream)
+	n := 0
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if g.Put(e.Key, ByteView{b: e.Value, e: e.Expire, f: e.Flags, v: e.Version}) {
+			n++
+		}
+	}
+}
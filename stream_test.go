@@ -0,0 +1,98 @@
+package distributecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startStreamServer 启动一个 RPC 服务器，g 中预先写入 n 个大小为 size 的缓存值。
+func startStreamServer(t *testing.T, n, size int) (*Group, *Client) {
+	t.Helper()
+	g := NewGroup(t.Name(), 1<<30, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	for i := 0; i < n; i++ {
+		g.Insert("key"+strconv.Itoa(i), ByteView{b: bytes.Repeat([]byte{byte(i)}, size)})
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := "tcp@" + lis.Addr().String()
+	go NewServer(g, t.Name(), addr).Accept(lis)
+	client, err := XDial(addr, &Option{ConnectTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return g, client
+}
+
+func (s *StreamReader) buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.chunks)
+}
+
+func TestDumpFlowControl(t *testing.T) {
+	const n, size = 64, 256 << 10 // 16MB，远大于发送窗口
+	_, client := startStreamServer(t, n, size)
+	prefix := "key"
+	stream, err := client.Stream(context.Background(), "Group.Dump", &prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// 不读取时，客户端缓存的帧数不会超过发送窗口
+	time.Sleep(200 * time.Millisecond)
+	if got := stream.buffered(); got > defaultStreamWindow {
+		t.Fatalf("client buffered %d frames without reading, window is %d", got, defaultStreamWindow)
+	}
+
+	dec := gob.NewDecoder(stream)
+	count := 0
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(e.Value) != size || e.Version == 0 {
+			t.Fatalf("entry %s: %d bytes, version %d", e.Key, len(e.Value), e.Version)
+		}
+		count++
+	}
+	if count != n {
+		t.Fatalf("dumped %d entries, want %d", count, n)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	_, client := startStreamServer(t, 64, 256<<10)
+	prefix := "key"
+	stream, err := client.Stream(context.Background(), "Group.Dump", &prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Close()
+
+	// 放弃读取后，同一连接上的其他调用不受影响
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key, reply := "key1", ""
+	if err := client.Call(ctx, "Group.Get", &key, &reply); err != nil || len(reply) != 256<<10 {
+		t.Fatalf("call after cancel: %d bytes, %v", len(reply), err)
+	}
+}
@@ -0,0 +1,86 @@
+package distributecache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// tlsProtocol 是 XDial 地址中表示 TLS 连接的协议名，例如 tls@localhost:1234。
+const tlsProtocol = "tls"
+
+// NewServerTLSConfig 根据证书和私钥创建服务端 TLS 配置。
+// caFile 不为空时开启双向认证（mTLS），要求客户端出示证书，并使用 caFile 中的 CA 校验客户端证书。
+func NewServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: load key pair: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig 创建客户端 TLS 配置。
+// caFile 用于校验服务端证书，为空时使用系统根证书；
+// certFile 和 keyFile 不为空时，在握手时向服务端出示客户端证书（mTLS）。
+func NewClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load key pair: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: read ca file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// dialConn 建立到服务端的连接。
+// network 为 tls，或者 opt.TLSConfig 不为空时，在 TCP 连接之上完成 TLS 握手。
+func dialConn(network, address string, opt *Option) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: opt.ConnectTimeout}
+	if network != tlsProtocol && opt.TLSConfig == nil {
+		return dialer.Dial(network, address)
+	}
+	if network == tlsProtocol {
+		network = "tcp"
+	}
+	config := opt.TLSConfig
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	// tls.DialWithDialer 在 ServerName 为空时会使用 address 中的主机名校验服务端证书
+	return tls.DialWithDialer(dialer, network, address, config)
+}
@@ -0,0 +1,196 @@
+package distributecache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 是测试时在本地生成的 CA，用于签发服务端和客户端证书。
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM 编码的 CA 证书文件
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), name+".pem")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue 签发一张证书，返回证书和私钥文件。client 为 true 时签发客户端证书，否则签发 127.0.0.1 的服务端证书。
+func (ca *testCA) issue(t *testing.T, name string, client bool) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		tmpl.IPAddresses = nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer 启动一个使用 config 的 RPC 服务器，返回 XDial 使用的地址。
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	g := NewGroup(t.Name(), 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lis.Close() })
+	addr := tlsProtocol + "@" + lis.Addr().String()
+	go NewServer(g, t.Name(), addr).AcceptTLS(lis, config)
+	return addr
+}
+
+// callTLS 使用 config 连接 addr 并调用一次 Group.Get。
+func callTLS(addr string, config *tls.Config) (string, error) {
+	client, err := XDial(addr, &Option{
+		MagicNumber:    MagicNumber,
+		CodecType:      DefaultOption.CodecType,
+		ConnectTimeout: 5 * time.Second,
+		TLSConfig:      config,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	key, reply := "k", ""
+	err = client.Call(ctx, "Group.Get", &key, &reply)
+	return reply, err
+}
+
+func TestAcceptTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	certFile, keyFile := ca.issue(t, "server", false)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, serverConfig)
+
+	clientConfig, err := NewClientTLSConfig(ca.file, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := callTLS(addr, clientConfig); err != nil || reply != "value of k" {
+		t.Fatalf("call over TLS = %q, %v", reply, err)
+	}
+
+	// 不信任服务端 CA 的客户端无法完成握手
+	other := newTestCA(t, "other")
+	untrusted, err := NewClientTLSConfig(other.file, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callTLS(addr, untrusted); err == nil {
+		t.Fatal("expected an error when the server certificate is signed by an unknown CA")
+	}
+}
+
+func TestAcceptTLSMutual(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	certFile, keyFile := ca.issue(t, "server", false)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, ca.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, serverConfig)
+
+	clientCert, clientKey := ca.issue(t, "client", true)
+	clientConfig, err := NewClientTLSConfig(ca.file, clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := callTLS(addr, clientConfig); err != nil || reply != "value of k" {
+		t.Fatalf("call over mTLS = %q, %v", reply, err)
+	}
+
+	noCert, err := NewClientTLSConfig(ca.file, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callTLS(addr, noCert); err == nil {
+		t.Fatal("expected an error when the client presents no certificate")
+	}
+
+	// 由其他 CA 签发的客户端证书会被服务端拒绝
+	other := newTestCA(t, "other")
+	unknownCert, unknownKey := other.issue(t, "intruder", true)
+	unknown, err := NewClientTLSConfig(ca.file, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callTLS(addr, unknown); err == nil {
+		t.Fatal("expected an error when the client certificate is signed by an unknown CA")
+	}
+}
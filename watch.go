@@ -0,0 +1,75 @@
+package distributecache
+
+import (
+	"strings"
+	"sync"
+)
+
+// EventType 是缓存变更的类型
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event 是一次缓存变更，EventDelete 的 Value 为空。
+// 只有 Insert、Delete 等显式的写操作会产生事件，缓存未命中时从数据源加载的值不会。
+type Event struct {
+	Type  EventType
+	Key   string
+	Value ByteView
+}
+
+// watchBuffer 是每个观察者缓冲的事件数，观察者处理过慢导致缓冲区写满时会被关闭，
+// 避免一个缓慢的观察者阻塞所有写操作。
+const watchBuffer = 256
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+type watchers struct {
+	mu   sync.Mutex
+	subs map[*watcher]struct{}
+}
+
+// Watch 订阅键以 prefix 开头的缓存变更，返回接收事件的 channel 和取消订阅的函数。
+// 取消订阅，或者观察者处理过慢时，channel 会被关闭。
+func (g *Group) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{prefix: prefix, ch: make(chan Event, watchBuffer)}
+	ws := &g.watchers
+	ws.mu.Lock()
+	if ws.subs == nil {
+		ws.subs = make(map[*watcher]struct{})
+	}
+	ws.subs[w] = struct{}{}
+	ws.mu.Unlock()
+	return w.ch, func() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		if _, ok := ws.subs[w]; ok {
+			delete(ws.subs, w)
+			close(w.ch)
+		}
+	}
+}
+
+// notify 把一次变更发送给所有匹配的观察者。
+func (g *Group) notify(typ EventType, key string, value ByteView) {
+	ws := &g.watchers
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.subs {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- Event{Type: typ, Key: key, Value: value}:
+		default:
+			delete(ws.subs, w)
+			close(w.ch)
+		}
+	}
+}